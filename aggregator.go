@@ -19,22 +19,193 @@ package main
 
 import (
 	"context"
+	`fmt`
 	`log`
+	`os`
+	`path/filepath`
+	`strings`
+	`sync`
 )
 
+// RecordAggregate tracks the pages of a single record as they finish the pipeline so that exactly one Document is
+// compiled for the record once every extracted page has either completed or failed.
+type RecordAggregate struct {
+	mu        sync.Mutex
+	expected  int
+	completed map[int]string
+	failed    map[int]error
+	emitted   bool
+}
+
+func recordAggregate(recordIdentifier string) *RecordAggregate {
+	ira, _ := sm_record_aggregates.LoadOrStore(recordIdentifier, &RecordAggregate{
+		expected:  -1,
+		completed: make(map[int]string),
+		failed:    make(map[int]error),
+	})
+	return ira.(*RecordAggregate)
+}
+
+// aggregateExpectPages is called by extractPagesFromPdf once it knows how many pages of the record were sent into the
+// pipeline, which allows the aggregate to know when the last page has arrived.
+func aggregateExpectPages(ctx context.Context, recordIdentifier string, totalPages int) {
+	ra := recordAggregate(recordIdentifier)
+	ra.mu.Lock()
+	ra.expected = totalPages
+	ra.mu.Unlock()
+	aggregateCompileDocument(ctx, recordIdentifier)
+}
+
 func aggregatePendingPage(ctx context.Context, pp PendingPage) {
+	defer wg_active_tasks.Done()
+	journalPageStage(pp, c_journal_page_completed)
+	ra := recordAggregate(pp.RecordIdentifier)
+	ra.mu.Lock()
+	if ra.emitted {
+		ra.mu.Unlock()
+		log.Printf("ignoring page %d (ID %v) because the document of record %v was already compiled", pp.PageNumber, pp.Identifier, pp.RecordIdentifier)
+		return
+	}
+	ra.completed[pp.PageNumber] = pp.Identifier
+	delete(ra.failed, pp.PageNumber)
+	ra.mu.Unlock()
+	aggregateCompileDocument(ctx, pp.RecordIdentifier)
+}
+
+// aggregateFailedPage records that the page will never reach ch_CompletedPage so the record is not held open forever.
+func aggregateFailedPage(ctx context.Context, pp PendingPage, reason error) {
+	defer wg_active_tasks.Done()
+	log.Printf("page %d (ID %v) of record %v failed and will be compiled without it due to error %v", pp.PageNumber, pp.Identifier, pp.RecordIdentifier, reason)
+	ra := recordAggregate(pp.RecordIdentifier)
+	ra.mu.Lock()
+	if ra.emitted {
+		ra.mu.Unlock()
+		return
+	}
+	if _, completed := ra.completed[pp.PageNumber]; !completed {
+		ra.failed[pp.PageNumber] = reason
+	}
+	ra.mu.Unlock()
+	aggregateCompileDocument(ctx, pp.RecordIdentifier)
+}
+
+func aggregateCompileDocument(ctx context.Context, recordIdentifier string) {
+	ra := recordAggregate(recordIdentifier)
+	ra.mu.Lock()
+	if ra.emitted || ra.expected < 0 || len(ra.completed)+len(ra.failed) < ra.expected {
+		ra.mu.Unlock()
+		return
+	}
+	ra.emitted = true
+	completed := ra.completed
+	failed := len(ra.failed)
+	expected := ra.expected
+	ra.completed = nil
+	ra.failed = nil
+	ra.mu.Unlock()
+
+	if len(completed) == 0 {
+		log.Printf("not compiling a document for record %v because none of its %d pages completed", recordIdentifier, expected)
+		return
+	}
+
+	ird, found := sm_documents.Load(recordIdentifier)
+	if !found {
+		log.Printf("cannot compile the document for record %v because it is missing from sm_documents", recordIdentifier)
+		return
+	}
+	rd, ok := ird.(ResultData)
+	if !ok {
+		log.Printf("cannot typecast the sm_documents entry for %v as a .(ResultData)", recordIdentifier)
+		return
+	}
+
+	document := Document{
+		Identifier: rd.Identifier,
+		URL:        rd.URL,
+		Pages:      make(map[int64]Page, len(completed)),
+		TotalPages: int64(expected),
+		Collection: aggregateCollection(ctx, rd),
+	}
+
+	var coverPageNumber = -1
+	for pageNumber, pageIdentifier := range completed {
+		ipp, found := sm_pages.Load(pageIdentifier)
+		if !found {
+			log.Printf("page %v of record %v is missing from sm_pages", pageIdentifier, recordIdentifier)
+			continue
+		}
+		pp, ok := ipp.(PendingPage)
+		if !ok {
+			log.Printf("cannot typecast the sm_pages entry for %v as a .(PendingPage)", pageIdentifier)
+			continue
+		}
+		document.Pages[int64(pageNumber)] = aggregatePage(pp)
+		if coverPageNumber < 0 || pageNumber < coverPageNumber {
+			coverPageNumber = pageNumber
+			document.CoverPageIdentifier = pp.Identifier
+		}
+	}
+
+	log.Printf("compiled document %v with %d of %d pages (%d failed), sending it into ch_CompiledDocument", document.Identifier, len(document.Pages), expected, failed)
+	wg_active_tasks.Add(1)
+	// 1 - compileDocumentSql - done
 	if ch_CompiledDocument.CanWrite() {
-		err := ch_CompiledDocument.Write(Document{
-			Identifier:          pp.RecordIdentifier,
-			URL:                 "",
-			Pages:               nil,
-			TotalPages:          0,
-			CoverPageIdentifier: "",
-			Collection:          Collection{},
-		})
+		err := ch_CompiledDocument.Write(document)
 		if err != nil {
+			wg_active_tasks.Done()
 			log.Printf("cant write to the ch_CompiledDocument channel due to error %v", err)
 			return
 		}
+	} else {
+		wg_active_tasks.Done()
+	}
+}
+
+func aggregatePage(pp PendingPage) Page {
+	page := Page{
+		Identifier:         pp.Identifier,
+		DocumentIdentifier: pp.RecordIdentifier,
+		PageNumber:         int64(pp.PageNumber),
+		Metadata:           make(map[string]string),
+	}
+	if len(pp.Language) > 0 {
+		page.Metadata["language"] = pp.Language
+	}
+
+	fullText, err := os.ReadFile(pp.BestTextPath())
+	if err != nil {
+		log.Printf("failed to read the full text of page %v from %v due to error %v", pp.Identifier, pp.BestTextPath(), err)
+	} else {
+		page.FullText = string(fullText)
+		page.FullTextGematria = NewGemScore(page.FullText)
+	}
+
+	for _, locations := range [][]CountableLocation{pp.Geography.Countries, pp.Geography.States, pp.Geography.Cities} {
+		for _, cl := range locations {
+			if cl.Location != nil {
+				page.Locations = append(page.Locations, cl.Location)
+			}
+		}
+	}
+	return page
+}
+
+// aggregateCollection returns the Collection of the record, which is named after its "collection" metadata or, when
+// that is missing, after the file that was imported. Every record that shares a name shares the same identifier.
+func aggregateCollection(ctx context.Context, rd ResultData) Collection {
+	name := strings.TrimSpace(rd.Metadata["collection"])
+	if len(name) == 0 {
+		loadedFile := filepath.Base(fmt.Sprintf("%s", ctx.Value(CtxKey("filename"))))
+		name = strings.TrimSuffix(loadedFile, filepath.Ext(loadedFile))
+	}
+
+	mu_collections.Lock()
+	defer mu_collections.Unlock()
+	collection, found := m_collections[name]
+	if !found {
+		collection = Collection{Identifier: NewCollectionIdentifier(name), Name: name}
+		m_collections[name] = collection
 	}
+	return collection
 }
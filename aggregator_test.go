@@ -0,0 +1,126 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`errors`
+	`os`
+	`path/filepath`
+	`sync`
+	`testing`
+)
+
+// resetAggregates clears the records and pages that earlier tests or earlier runs of -count left behind
+func resetAggregates(t *testing.T) {
+	for _, sm := range []*sync.Map{&sm_record_aggregates, &sm_documents, &sm_pages} {
+		sm.Range(func(key, value any) bool {
+			sm.Delete(key)
+			return true
+		})
+	}
+	t.Cleanup(func() {
+		for len(ch_CompiledDocument.Chan()) > 0 {
+			<-ch_CompiledDocument.Chan()
+			wg_active_tasks.Done()
+		}
+	})
+}
+
+func Test_aggregatePendingPage(t *testing.T) {
+	resetAggregates(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	rd := ResultData{
+		Identifier: "2023AGGREG",
+		URL:        "https://www.archives.gov/files/research/jfk/releases/2023/104-10061-10328.pdf",
+		DataDir:    dir,
+		Metadata:   map[string]string{"collection": "JFK"},
+	}
+	sm_documents.Store(rd.Identifier, rd)
+
+	var pages []PendingPage
+	for pgNo := 1; pgNo <= 3; pgNo++ {
+		pp := PendingPage{
+			Identifier:       rd.Identifier + string(rune('A'+pgNo)),
+			RecordIdentifier: rd.Identifier,
+			PageNumber:       pgNo,
+			OCRTextPath:      filepath.Join(dir, "ocr.txt"),
+		}
+		sm_pages.Store(pp.Identifier, pp)
+		pages = append(pages, pp)
+	}
+	if err := os.WriteFile(pages[0].OCRTextPath, []byte("AMLASH"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wg_active_tasks.Add(3)
+	aggregatePendingPage(ctx, pages[2])
+	aggregateFailedPage(ctx, pages[0], errors.New("pdftoppm failed"))
+	aggregatePendingPage(ctx, pages[1])
+	if len(ch_CompiledDocument.Chan()) != 0 {
+		t.Fatalf("Expected no document before the expected page count is known")
+	}
+
+	aggregateExpectPages(ctx, rd.Identifier, 3)
+	aggregateExpectPages(ctx, rd.Identifier, 3)
+	if len(ch_CompiledDocument.Chan()) != 1 {
+		t.Fatalf("Expected exactly one document, but got %d", len(ch_CompiledDocument.Chan()))
+	}
+	document := (<-ch_CompiledDocument.Chan()).(Document)
+	wg_active_tasks.Done()
+
+	if document.Identifier != rd.Identifier || document.URL != rd.URL || document.TotalPages != 3 {
+		t.Errorf("Expected document %v with 3 pages, but got %v", rd.Identifier, document)
+	}
+	if len(document.Pages) != 2 || document.CoverPageIdentifier != pages[1].Identifier {
+		t.Errorf("Expected pages 2 and 3 with page 2 as the cover, but got %v", document.Pages)
+	}
+	if document.Pages[2].FullText != "AMLASH" {
+		t.Errorf("Expected the full text to be read from the OCR file, but got %v", document.Pages[2].FullText)
+	}
+	if document.Collection.Name != "JFK" || len(document.Collection.Identifier) == 0 {
+		t.Errorf("Expected the JFK collection, but got %v", document.Collection)
+	}
+}
+
+func Test_aggregatePendingPageAfterCompile(t *testing.T) {
+	resetAggregates(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	rd := ResultData{Identifier: "2023AGAGIN", URL: "https://www.archives.gov/files/research/jfk/releases/2023/104-10061-10329.pdf", DataDir: dir}
+	sm_documents.Store(rd.Identifier, rd)
+	pp := PendingPage{Identifier: rd.Identifier + "B", RecordIdentifier: rd.Identifier, PageNumber: 1, OCRTextPath: filepath.Join(dir, "ocr.txt")}
+	sm_pages.Store(pp.Identifier, pp)
+
+	wg_active_tasks.Add(1)
+	aggregateExpectPages(ctx, rd.Identifier, 1)
+	aggregatePendingPage(ctx, pp)
+	if len(ch_CompiledDocument.Chan()) != 1 {
+		t.Fatalf("Expected exactly one document, but got %d", len(ch_CompiledDocument.Chan()))
+	}
+
+	// the same record sent through the pipeline again must not reopen the compiled aggregate
+	wg_active_tasks.Add(2)
+	aggregatePendingPage(ctx, pp)
+	aggregateFailedPage(ctx, pp, errors.New("pdftoppm failed"))
+	aggregateExpectPages(ctx, rd.Identifier, 1)
+	if len(ch_CompiledDocument.Chan()) != 1 {
+		t.Errorf("Expected the record to be compiled once, but got %d documents", len(ch_CompiledDocument.Chan()))
+	}
+}
@@ -31,8 +31,12 @@ import (
 )
 
 func analyze_StartOnFullText(ctx context.Context, pp PendingPage) {
+	completed := false
 	defer func() {
 		pp_save(pp)
+		if completed {
+			journalPageStage(pp, c_journal_page_text)
+		}
 		wg_active_tasks.Done()
 		if ch_AnalyzeCryptonyms.CanWrite() {
 			err := ch_AnalyzeCryptonyms.Write(pp)
@@ -42,17 +46,22 @@ func analyze_StartOnFullText(ctx context.Context, pp PendingPage) {
 			}
 		}
 	}()
-	file, fileErr := os.ReadFile(pp.OCRTextPath)
+	file, fileErr := os.ReadFile(pp.BestTextPath())
 	if fileErr != nil {
-		log.Printf("Error opening file %q: %v\n", pp.OCRTextPath, fileErr)
+		log.Printf("Error opening file %q: %v\n", pp.BestTextPath(), fileErr)
 		return
 	}
 	pp.Dates = extractDates(string(file))
+	completed = true
 }
 
 func analyzeCryptonyms(ctx context.Context, pp PendingPage) {
+	completed := false
 	defer func() {
 		pp_save(pp)
+		if completed {
+			journalPageStage(pp, c_journal_page_cryptonyms)
+		}
 		wg_active_tasks.Done()
 		if ch_AnalyzeLocations.CanWrite() {
 			err := ch_AnalyzeLocations.Write(pp)
@@ -64,9 +73,9 @@ func analyzeCryptonyms(ctx context.Context, pp PendingPage) {
 	}()
 
 	var result []string
-	file, fileErr := os.ReadFile(pp.OCRTextPath)
+	file, fileErr := os.ReadFile(pp.BestTextPath())
 	if fileErr != nil {
-		log.Printf("Error opening file %q: %v\n", pp.OCRTextPath, fileErr)
+		log.Printf("Error opening file %q: %v\n", pp.BestTextPath(), fileErr)
 		return
 	}
 	for key := range m_cryptonyms {
@@ -75,11 +84,16 @@ func analyzeCryptonyms(ctx context.Context, pp PendingPage) {
 		}
 	}
 	pp.Cryptonyms = result
+	completed = true
 }
 
 func analyzeLocations(ctx context.Context, pp PendingPage) {
+	completed := false
 	defer func() {
 		pp_save(pp)
+		if completed {
+			journalPageStage(pp, c_journal_page_locations)
+		}
 		wg_active_tasks.Done()
 		if ch_AnalyzeGematria.CanWrite() {
 			err := ch_AnalyzeGematria.Write(pp)
@@ -96,7 +110,7 @@ func analyzeLocations(ctx context.Context, pp PendingPage) {
 		}
 		select {
 		case <-time.After(9 * time.Second):
-			log.Printf("waiting for locations to finish loading before running analyzeLocations(%v)", pp.OCRTextPath)
+			log.Printf("waiting for locations to finish loading before running analyzeLocations(%v)", pp.BestTextPath())
 			continue
 		case <-ctx.Done():
 			return
@@ -117,9 +131,9 @@ func analyzeLocations(ctx context.Context, pp PendingPage) {
 			close(done)
 		}()
 
-		b_fullText, fileErr := os.ReadFile(pp.OCRTextPath)
+		b_fullText, fileErr := os.ReadFile(pp.BestTextPath())
 		if fileErr != nil {
-			log.Printf("Error opening file %q: %v\n", pp.OCRTextPath, fileErr)
+			log.Printf("Error opening file %q: %v\n", pp.BestTextPath(), fileErr)
 			return
 		}
 		fullText := strings.ToLower(string(b_fullText))
@@ -128,7 +142,7 @@ func analyzeLocations(ctx context.Context, pp PendingPage) {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			log.Printf("checking pp PDF %v location countries inside a total of %d countries", pp.OCRTextPath, len(m_location_countries))
+			log.Printf("checking pp PDF %v location countries inside a total of %d countries", pp.BestTextPath(), len(m_location_countries))
 			var e_countries = make(map[string]*Location)
 			for _, country := range m_location_countries {
 				c := strings.ToLower(country.Country)
@@ -152,7 +166,7 @@ func analyzeLocations(ctx context.Context, pp PendingPage) {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			log.Printf("checking pp PDF %v location states inside a total of %d states", pp.OCRTextPath, len(m_location_states))
+			log.Printf("checking pp PDF %v location states inside a total of %d states", pp.BestTextPath(), len(m_location_states))
 			var e_states = make(map[string]*Location)
 			for _, state := range m_location_states {
 				s := strings.ToLower(state.State)
@@ -176,7 +190,7 @@ func analyzeLocations(ctx context.Context, pp PendingPage) {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			log.Printf("checking pp PDF %v location cities inside a total of %d cities", pp.OCRTextPath, len(m_location_cities))
+			log.Printf("checking pp PDF %v location cities inside a total of %d cities", pp.BestTextPath(), len(m_location_cities))
 			var e_cities = make(map[string]*Location)
 			for _, city := range m_location_cities {
 				c := strings.ToLower(city.City)
@@ -210,6 +224,7 @@ func analyzeLocations(ctx context.Context, pp PendingPage) {
 		case geography, opened := <-done:
 			if opened {
 				pp.Geography = geography
+				completed = true
 			}
 			return
 		}
@@ -217,8 +232,12 @@ func analyzeLocations(ctx context.Context, pp PendingPage) {
 }
 
 func analyzeGematria(ctx context.Context, pp PendingPage) {
+	completed := false
 	defer func() {
 		pp_save(pp)
+		if completed {
+			journalPageStage(pp, c_journal_page_gematria)
+		}
 		wg_active_tasks.Done()
 		if ch_AnalyzeDictionary.CanWrite() {
 			err := ch_AnalyzeDictionary.Write(pp)
@@ -235,7 +254,7 @@ func analyzeGematria(ctx context.Context, pp PendingPage) {
 		}
 		select {
 		case <-time.After(9 * time.Second):
-			log.Printf("waiting for word dictionary to finish loading before running analyzeGematria(%v)", pp.OCRTextPath)
+			log.Printf("waiting for word dictionary to finish loading before running analyzeGematria(%v)", pp.BestTextPath())
 			continue
 		case <-ctx.Done():
 			return
@@ -252,9 +271,9 @@ func analyzeGematria(ctx context.Context, pp PendingPage) {
 			close(done)
 		}()
 
-		file, fileErr := os.Open(pp.OCRTextPath)
+		file, fileErr := os.Open(pp.BestTextPath())
 		if fileErr != nil {
-			log.Printf("Error opening file %q: %v\n", pp.OCRTextPath, fileErr)
+			log.Printf("Error opening file %q: %v\n", pp.BestTextPath(), fileErr)
 			return
 		}
 		defer func() {
@@ -299,7 +318,7 @@ func analyzeGematria(ctx context.Context, pp PendingPage) {
 		case <-ctx.Done():
 			return
 		case <-done:
-			output := fmt.Sprintf("Words in OCR file %v", pp.OCRTextPath)
+			output := fmt.Sprintf("Words in OCR file %v", pp.BestTextPath())
 			var languages = map[string]int{}
 			var selectedLanguage string
 			var totalWords int
@@ -332,6 +351,7 @@ func analyzeGematria(ctx context.Context, pp PendingPage) {
 				output += fmt.Sprintf("-> %v (%v) = %v", wr.Word, wr.Language, wr.Gematria)
 			}
 			log.Println(output)
+			completed = true
 			return
 		}
 	}
@@ -339,8 +359,12 @@ func analyzeGematria(ctx context.Context, pp PendingPage) {
 }
 
 func analyzeWordIndexer(ctx context.Context, pp PendingPage) {
+	completed := false
 	defer func() {
 		pp_save(pp)
+		if completed {
+			journalPageStage(pp, c_journal_page_dictionary)
+		}
 		wg_active_tasks.Done()
 		if ch_CompletedPage.CanWrite() {
 			err := ch_CompletedPage.Write(pp)
@@ -357,12 +381,13 @@ func analyzeWordIndexer(ctx context.Context, pp PendingPage) {
 		}
 		select {
 		case <-time.After(9 * time.Second):
-			log.Printf("waiting for word dictionary to finish loading before running analyzeWordIndexer(%v)", pp.OCRTextPath)
+			log.Printf("waiting for word dictionary to finish loading before running analyzeWordIndexer(%v)", pp.BestTextPath())
 			continue
 		case <-ctx.Done():
 			return
 		}
 	}
 
+	completed = true
 	return
 }
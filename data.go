@@ -19,8 +19,11 @@ package main
 
 import (
 	"context"
+	"encoding/csv"
 	"fmt"
 	"image/color"
+	"net/http"
+	"os"
 	"path/filepath"
 	`regexp`
 	"sync"
@@ -35,9 +38,17 @@ import (
 )
 
 const (
-	c_retry_attempts     = 33
-	c_identifier_charset = "ABCDEFGHKMNPQRSTUVWXYZ123456789"
-	c_dir_permissions    = 0111
+	c_retry_attempts       = 33
+	c_identifier_charset   = "ABCDEFGHKMNPQRSTUVWXYZ123456789"
+	c_record_id_length     = 10
+	c_page_id_length       = 13
+	c_collection_id_length = 10
+	c_dir_permissions      = 0111
+	c_column_local_path    = "local_path"
+	c_column_sheet         = "sheet"
+	c_column_row_number    = "row_number"
+	c_partial_download_ext = ".part"
+	c_refresh_download_ext = ".refresh"
 )
 
 var (
@@ -57,12 +68,29 @@ var (
 	dir_data_directory    string
 	dir_current_directory string
 
+	// Files
+	file_journal  *os.File
+	file_rejected *os.File
+	csv_rejected  *csv.Writer
+
+	// Clients
+	http_client *http.Client
+	tool_runner ToolRunner = ExecToolRunner{}
+
+	// Plans
+	plan_dry_run DryRunPlan
+
 	// Maps
 	m_cryptonyms          = make(map[string]string)
 	m_location_cities     []*Location
 	m_location_countries  []*Location
 	m_location_states     []*Location
-	m_used_identifiers    = make(map[string]bool)
+	m_used_identifiers    = make(map[string]string) // identifier => seed that produced it (blank when random)
+	m_collections         = make(map[string]Collection)
+	m_journal_checksums   = make(map[string]string)
+	m_journal_records     = make(map[string]*JournalState)
+	m_journal_pages       = make(map[string]*JournalState)
+	m_journal_contents    = make(map[string]string) // pdf checksum => identifier of the record that owns the pages
 	m_required_binaries   = make(map[string]string)
 	m_language_dictionary = make(map[string]map[string]struct{})
 	m_gcm_jewish          = make(GemCodeMap)
@@ -96,7 +124,15 @@ var (
 	mu_location_countries = sync.RWMutex{}
 	mu_location_states    = sync.RWMutex{}
 	mu_location_cities    = sync.RWMutex{}
+	mu_collections        = sync.Mutex{}
+	mu_journal            = sync.Mutex{}
+	mu_rejected           = sync.Mutex{}
+	mu_dry_run            = sync.Mutex{}
+	mu_record_aliases     = sync.Mutex{}
+	once_http_client      = sync.Once{}
 	wg_active_tasks       = cwg.CountableWaitGroup{}
+	once_sql_schema       = sync.Once{}
+	once_pdfcpu_config    = sync.Once{}
 
 	// Command Line Flags
 	flag_s_file             = config.NewString("file", "", "CSV file of URL + Metadata")
@@ -106,11 +142,12 @@ var (
 	flag_i_buffer           = config.NewInt("buffer", reader_buffer_bytes, "Memory allocation for CSV buffer (min 168 * 1024 = 168KB)")
 	flag_b_sem_tesseract    = config.NewInt("tesseract", 1, "Semaphore Limiter for `tesseract` binary.")
 	flag_b_sem_download     = config.NewInt("download", 2, "Semaphore Limiter for downloading PDF files from URLs.")
-	flag_b_sem_pdfcpu       = config.NewInt("pdfcpu", 17, "Semaphore Limiter for `pdfcpu` binary.")
+	flag_b_sem_pdfcpu       = config.NewInt("pdfcpu", 17, "Semaphore Limiter for the in-process pdfcpu validation, optimization and page extraction.")
 	flag_b_sem_gs           = config.NewInt("gs", 17, "Semaphore Limiter for `gs` binary.")
 	flag_b_sem_pdftotext    = config.NewInt("pdftotext", 17, "Semaphore Limiter for `pdftotext` binary.")
 	flag_b_sem_convert      = config.NewInt("convert", 17, "Semaphore Limiter for `convert` binary.")
 	flag_b_sem_pdftoppm     = config.NewInt("pdftoppm", 17, "Semaphore Limiter for `pdftoppm` binary.")
+	flag_b_sem_qpdf         = config.NewInt("qpdf", 17, "Semaphore Limiter for the optional `qpdf` binary.")
 	flag_g_sem_png2jpg      = config.NewInt("png2jpg", 17, "Semaphore Limiter for converting PNG images to JPG.")
 	flag_g_sem_resize       = config.NewInt("resize", 17, "Semaphore Limiter for resize PNG or JPG images.")
 	flag_g_sem_shafile      = config.NewInt("shafile", 36, "Semaphore Limiter for calculating the SHA256 checksum of files.")
@@ -121,28 +158,61 @@ var (
 	flag_g_sem_wjsonfile    = config.NewInt("wjsonfile", 369, "Semaphore Limiter for writing a JSON file to disk.")
 	flag_g_jpg_quality      = config.NewInt("jpeg-quality", 71, "Quality percentage (as int 1-100) for compressing PNG images into JPEG files.")
 	flag_g_progressive_jpeg = config.NewBool("progressive", true, "Convert compressed JPEG images into progressive images.")
+	flag_s_sheet            = config.NewString("sheet", "", "Name or 1-based position of the XLSX sheet to load. Blank loads the first sheet.")
+	flag_b_all_sheets       = config.NewBool("all-sheets", false, "Load every sheet of the XLSX file instead of only the -sheet.")
+	flag_s_user_agent       = config.NewString("user-agent", "apario-contribution/1.0", "User-Agent header sent with every download.")
+	flag_d_download_timeout = config.NewDuration("download-timeout", 30*time.Minute, "Maximum time a single download attempt may take, including reading the PDF.")
+	flag_f_rate_limit       = config.NewFloat64("rate-limit", 2, "Maximum number of download requests per second to each host (0 to disable).")
+	flag_s_mirror           = config.NewString("mirror", "", "Directory with a local mirror of the PDFs (host/path/file.pdf) to read instead of downloading from the web.")
+	flag_s_serve_mirror     = config.NewString("serve-mirror", "", "Address such as localhost:8080 to serve the -mirror directory over HTTP as a test fixture server instead of running the pipeline.")
+	flag_b_refresh          = config.NewBool("refresh", false, "Ask the server whether each PDF that was already downloaded changed and run the changed records through the pipeline again.")
+	flag_b_dry_run          = config.NewBool("dry-run", false, "Print what would be downloaded, skipped and rejected without downloading or running any binaries.")
+	flag_s_profile          = config.NewString("profile", "", "Name of a built-in metadata profile (default, jfk) or path to a YAML/JSON profile mapping the -file headers. Blank selects one by the -file name.")
+	flag_i_worst_pages      = config.NewInt("worst-pages", 100, "Number of pages with the lowest OCR quality to list in ocr_quality.csv (0 for every page).")
+	flag_s_sql_dialect      = config.NewString("sql-dialect", "postgres", "SQL dialect used for the generated record.sql and schema files (postgres, mysql or sqlite).")
 	flag_g_log_file         = config.NewString("log", filepath.Join(".", "logs", fmt.Sprintf("engine-%04d-%02d-%02d-%02d-%02d-%02d.log", startedAt.Year(), startedAt.Month(), startedAt.Day(), startedAt.Hour(), startedAt.Minute(), startedAt.Second())), "File to save logs to. Default is logs/engine-YYYY-MM-DD-HH-MM-SS.log")
 
+	// Binary Timeouts
+	flag_d_timeout_gs        = config.NewDuration("timeout-gs", 30*time.Minute, "Maximum time a single `gs` command may run before its process group is killed (0 to disable).")
+	flag_d_timeout_pdftotext = config.NewDuration("timeout-pdftotext", 10*time.Minute, "Maximum time a single `pdftotext` command may run before its process group is killed (0 to disable).")
+	flag_d_timeout_convert   = config.NewDuration("timeout-convert", 5*time.Minute, "Maximum time a single `convert` command may run before its process group is killed (0 to disable).")
+	flag_d_timeout_pdftoppm  = config.NewDuration("timeout-pdftoppm", 10*time.Minute, "Maximum time a single `pdftoppm` command may run before its process group is killed (0 to disable).")
+	flag_d_timeout_tesseract = config.NewDuration("timeout-tesseract", 10*time.Minute, "Maximum time a single `tesseract` command may run before its process group is killed (0 to disable).")
+	flag_d_timeout_qpdf      = config.NewDuration("timeout-qpdf", 10*time.Minute, "Maximum time a single `qpdf` command may run before its process group is killed (0 to disable).")
+
+	// Text Layer
+	flag_b_force_ocr           = config.NewBool("force-ocr", false, "Run tesseract on every page, even when the text layer of the page is good enough to skip it.")
+	flag_i_skip_ocr_chars      = config.NewInt("skip-ocr-chars", 200, "Minimum number of characters in the text layer of a page before tesseract can be skipped.")
+	flag_f_skip_ocr_dictionary = config.NewFloat64("skip-ocr-dictionary", 0.7, "Minimum share of the words in the text layer of a page found in the dictionaries before tesseract can be skipped.")
+
 	// Binary Dependencies
 	sl_required_binaries = []string{
-		"pdfcpu",
 		"gs",
 		"pdftotext",
 		"convert",
 		"pdftoppm",
 		"tesseract",
 	}
+	sl_optional_binaries = []string{
+		"qpdf",
+	}
 
 	// Atomics
 	a_b_dictionary_loaded = atomic.Bool{}
 	a_b_gematria_loaded   = atomic.Bool{}
 	a_b_locations_loaded  = atomic.Bool{}
 	a_i_total_pages       = atomic.Int64{}
+	a_i_rejected_rows     = atomic.Int64{}
+	a_b_dry_run           = atomic.Bool{}
 
 	// Concurrent Maps
-	sm_page_directories sync.Map
-	sm_documents        sync.Map
-	sm_pages            sync.Map
+	sm_page_directories  sync.Map
+	sm_documents         sync.Map
+	sm_pages             sync.Map
+	sm_record_aggregates sync.Map
+	sm_records_in_flight sync.Map // record identifier => URL of the records that processRecord sent into the pipeline
+	sm_host_limiters     sync.Map
+	sm_running_tools     sync.Map // pid => *exec.Cmd of the binaries that are running
 
 	// Semaphores
 	sem_tesseract  = sema.New(*flag_b_sem_tesseract)
@@ -152,6 +222,7 @@ var (
 	sem_pdftotext  = sema.New(*flag_b_sem_pdftotext)
 	sem_convert    = sema.New(*flag_b_sem_convert)
 	sem_pdftoppm   = sema.New(*flag_b_sem_pdftoppm)
+	sem_qpdf       = sema.New(*flag_b_sem_qpdf)
 	sem_png2jpg    = sema.New(*flag_g_sem_png2jpg)
 	sem_resize     = sema.New(*flag_g_sem_resize)
 	sem_shafile    = sema.New(*flag_g_sem_shafile)
@@ -235,7 +306,47 @@ type ResultData struct {
 	ExtractedTextPath string            `json:"extracted_text_path"`
 	RecordPath        string            `json:"record_path"`
 	TotalPages        int64             `json:"total_pages"`
+	ReleasedPages     int64             `json:"released_pages,omitempty"`
 	Metadata          map[string]string `json:"metadata"`
+	History           []PDFChecksum     `json:"history,omitempty"`
+	Normalization     *PDFNormalization `json:"normalization,omitempty"`
+	Reconciliation    *Reconciliation   `json:"reconciliation,omitempty"`
+	AliasOf           *RecordAlias      `json:"alias_of,omitempty"`
+	Aliases           []RecordAlias     `json:"aliases,omitempty"`
+}
+
+// PDFNormalization records which repair of normalizePdf produced the PDF of a record, along with the repairs that
+// were tried before it.
+type PDFNormalization struct {
+	Repair       string    `json:"repair"`
+	OriginalPath string    `json:"original_path"`
+	Failed       []string  `json:"failed,omitempty"`
+	PageCount    int       `json:"page_count"`
+	NormalizedAt time.Time `json:"normalized_at"`
+}
+
+// Reconciliation compares the pages that the metadata of a record declared with the pages that extractPagesFromPdf
+// found in its PDF. The Mismatches are empty when every known count agrees.
+type Reconciliation struct {
+	Declared     int64     `json:"declared"`
+	Released     int64     `json:"released,omitempty"`
+	Actual       int64     `json:"actual"`
+	Mismatches   []string  `json:"mismatches,omitempty"`
+	Collection   string    `json:"collection,omitempty"`
+	ReconciledAt time.Time `json:"reconciled_at"`
+}
+
+// RecordAlias points from a record to another record whose PDF has the same PDFChecksum.
+type RecordAlias struct {
+	Identifier string `json:"identifier"`
+	URL        string `json:"url"`
+	DataDir    string `json:"data_dir"`
+}
+
+// PDFChecksum is a previous PDFChecksum of a record whose PDF was replaced by a -refresh run.
+type PDFChecksum struct {
+	Checksum   string    `json:"pdf_checksum"`
+	ReplacedAt time.Time `json:"replaced_at"`
 }
 
 type JPEG struct {
@@ -249,21 +360,33 @@ type PNG struct {
 }
 
 type PendingPage struct {
-	Identifier       string              `json:"identifier"`
-	RecordIdentifier string              `json:"record_identifier"`
-	PageNumber       int                 `json:"page_number"`
-	PDFPath          string              `json:"pdf_path"`
-	PagesDir         string              `json:"pages_dir"`
-	OCRTextPath      string              `json:"ocr_text_path"`
-	ManifestPath     string              `json:"manifest_path"`
-	Language         string              `json:"language"`
-	Words            []WordResult        `json:"words"`
-	Cryptonyms       []string            `json:"cryptonyms"`
-	Dates            []time.Time         `json:"dates"`
-	Geography        Geography           `json:"geography"`
-	Gematrias        map[string]Gematria `json:"gematrias"`
-	JPEG             JPEG                `json:"jpeg"`
-	PNG              PNG                 `json:"png"`
+	Identifier       string                 `json:"identifier"`
+	RecordIdentifier string                 `json:"record_identifier"`
+	PageNumber       int                    `json:"page_number"`
+	PDFPath          string                 `json:"pdf_path"`
+	PagesDir         string                 `json:"pages_dir"`
+	OCRTextPath      string                 `json:"ocr_text_path"`
+	OCRTSVPath       string                 `json:"ocr_tsv_path"`
+	TextLayerPath    string                 `json:"text_layer_path"`
+	TextPath         string                 `json:"text_path"`
+	TextSource       string                 `json:"text_source"`
+	TextQuality      map[string]TextQuality `json:"text_quality,omitempty"`
+	OCRSkipped       bool                   `json:"ocr_skipped"`
+	OCRReason        string                 `json:"ocr_reason,omitempty"`
+	OCRWords         []OCRWord              `json:"ocr_words,omitempty"`
+	OCRQuality       *OCRQuality            `json:"ocr_quality,omitempty"`
+	ManifestPath     string                 `json:"manifest_path"`
+	Language         string                 `json:"language"`
+	Words            []WordResult           `json:"words"`
+	Cryptonyms       []string               `json:"cryptonyms"`
+	Dates            []time.Time            `json:"dates"`
+	Geography        Geography              `json:"geography"`
+	Gematrias        map[string]Gematria    `json:"gematrias"`
+	JPEG             JPEG                   `json:"jpeg"`
+	PNG              PNG                    `json:"png"`
+	Failed           bool                   `json:"failed,omitempty"`
+	FailedStage      string                 `json:"failed_stage,omitempty"`
+	FailedReason     string                 `json:"failed_reason,omitempty"`
 }
 
 type Images struct {
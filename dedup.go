@@ -0,0 +1,181 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`fmt`
+	`log`
+	`os`
+	`path/filepath`
+)
+
+// writeRecordJson writes the record.json of the rd while keeping the Aliases that other records added to it.
+func writeRecordJson(rd ResultData) error {
+	mu_record_aliases.Lock()
+	defer mu_record_aliases.Unlock()
+	previous, previousErr := ReadResultDataFromJson(rd.RecordPath)
+	if previousErr == nil {
+		for _, alias := range previous.Aliases {
+			rd.Aliases = appendRecordAlias(rd.Aliases, alias)
+		}
+	}
+	return WriteResultDataToJson(rd)
+}
+
+// dedupRecord looks up the checksum of the rd's PDF in the content store. When another record already owns that PDF,
+// the rd becomes an alias of it: its pages directory links to the pages of the owner and both record.json files
+// point at each other. The rd is returned with its AliasOf set and true when it is a duplicate.
+func dedupRecord(rd ResultData) (ResultData, bool, error) {
+	owner := journalContentOwner(rd.PDFChecksum, rd.Identifier)
+	if owner == rd.Identifier {
+		return rd, false, nil
+	}
+	ownerUrlChecksum, found := journalRecordUrlChecksum(owner)
+	if !found {
+		return rd, false, fmt.Errorf("the record %v that owns the PDF checksum %v is missing from the journal", owner, rd.PDFChecksum)
+	}
+	ownerDataDir := filepath.Join(dir_data_directory, ownerUrlChecksum)
+	ownerRecordPath := filepath.Join(ownerDataDir, "record.json")
+
+	mu_record_aliases.Lock()
+	defer mu_record_aliases.Unlock()
+	ownerRd, err := ReadResultDataFromJson(ownerRecordPath)
+	if err != nil {
+		return rd, false, fmt.Errorf("cannot load the record.json %v of the record %v that owns the PDF of %v due to error %v", ownerRecordPath, owner, rd.Identifier, err)
+	}
+
+	err = linkRecordPages(filepath.Join(rd.DataDir, "pages"), filepath.Join(ownerDataDir, "pages"))
+	if err != nil {
+		return rd, false, err
+	}
+
+	rd.AliasOf = &RecordAlias{Identifier: ownerRd.Identifier, URL: ownerRd.URL, DataDir: ownerRd.DataDir}
+	err = WriteResultDataToJson(rd)
+	if err != nil {
+		return rd, false, err
+	}
+	ownerRd.Aliases = appendRecordAlias(ownerRd.Aliases, RecordAlias{Identifier: rd.Identifier, URL: rd.URL, DataDir: rd.DataDir})
+	err = WriteResultDataToJson(ownerRd)
+	if err != nil {
+		return rd, false, err
+	}
+	log.Printf("record %v (URL %v) has the same PDF as record %v (URL %v), linking its pages instead of processing them again", rd.Identifier, rd.URL, ownerRd.Identifier, ownerRd.URL)
+	return rd, true, nil
+}
+
+// resetRecordAliases undoes the dedupRecord of every alias listed in the record.json at recordPath, for when the PDF
+// of that record changed on refresh. The aliases lose their pages link, alias_of, record.sql and journal entries so
+// that the next time their rows are processed they are deduplicated against the checksum of their own PDF again.
+func resetRecordAliases(recordPath string) error {
+	mu_record_aliases.Lock()
+	defer mu_record_aliases.Unlock()
+	ownerRd, err := ReadResultDataFromJson(recordPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if len(ownerRd.Aliases) == 0 {
+		return nil
+	}
+	for _, alias := range ownerRd.Aliases {
+		journalResetRecord(alias.Identifier)
+		pagesDir := filepath.Join(alias.DataDir, "pages")
+		if info, statErr := os.Lstat(pagesDir); statErr == nil && info.Mode()&os.ModeSymlink != 0 {
+			err = os.Remove(pagesDir)
+			if err != nil {
+				return err
+			}
+		}
+		err = os.Remove(filepath.Join(alias.DataDir, "record.sql"))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		aliasRd, aliasErr := ReadResultDataFromJson(filepath.Join(alias.DataDir, "record.json"))
+		if aliasErr == nil && aliasRd.AliasOf != nil {
+			aliasRd.AliasOf = nil
+			err = WriteResultDataToJson(aliasRd)
+			if err != nil {
+				return err
+			}
+		}
+		log.Printf("record %v (URL %v) is no longer an alias of record %v because its PDF changed, it will be deduplicated again", alias.Identifier, alias.URL, ownerRd.Identifier)
+	}
+	ownerRd.Aliases = nil
+	return WriteResultDataToJson(ownerRd)
+}
+
+// aliasDocument returns the Document of a duplicate record, which has no pages of its own and uses the cover page
+// of the record that owns its PDF, so that compileDocumentSql can still write its documents row and journal it.
+func aliasDocument(ctx context.Context, rd ResultData) Document {
+	document := Document{
+		Identifier: rd.Identifier,
+		URL:        rd.URL,
+		Pages:      make(map[int64]Page),
+		TotalPages: rd.TotalPages,
+		Collection: aggregateCollection(ctx, rd),
+	}
+	if rd.AliasOf == nil {
+		return document
+	}
+	ownerRecordPath := filepath.Join(rd.AliasOf.DataDir, "record.json")
+	ownerRd, err := ReadResultDataFromJson(ownerRecordPath)
+	if err != nil {
+		log.Printf("compiling alias %v without the pages of record %v because %v cannot be loaded due to error %v", rd.Identifier, rd.AliasOf.Identifier, ownerRecordPath, err)
+		return document
+	}
+	if ownerRd.Reconciliation != nil && ownerRd.Reconciliation.Actual > 0 {
+		document.TotalPages = ownerRd.Reconciliation.Actual
+	} else if ownerRd.Normalization != nil && ownerRd.Normalization.PageCount > 0 {
+		document.TotalPages = int64(ownerRd.Normalization.PageCount)
+	} else if ownerRd.TotalPages > 0 {
+		document.TotalPages = ownerRd.TotalPages
+	}
+	if document.TotalPages > 0 {
+		document.CoverPageIdentifier = journalPageIdentifier(ownerRd.Identifier, 1)
+	}
+	return document
+}
+
+// linkRecordPages replaces the pages directory of a duplicate record with a relative symlink to the pages of the
+// record that owns the PDF.
+func linkRecordPages(pagesDir string, ownerPagesDir string) error {
+	target, err := filepath.Rel(filepath.Dir(pagesDir), ownerPagesDir)
+	if err != nil {
+		return err
+	}
+	if existing, linkErr := os.Readlink(pagesDir); linkErr == nil && existing == target {
+		return nil
+	}
+	err = os.RemoveAll(pagesDir)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(target, pagesDir)
+}
+
+func appendRecordAlias(aliases []RecordAlias, alias RecordAlias) []RecordAlias {
+	for _, existing := range aliases {
+		if existing.Identifier == alias.Identifier {
+			return aliases
+		}
+	}
+	return append(aliases, alias)
+}
@@ -0,0 +1,155 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`os`
+	`path/filepath`
+	`testing`
+)
+
+func Test_dedupRecord(t *testing.T) {
+	directory := dir_data_directory
+	reset := func() {
+		m_journal_checksums = make(map[string]string)
+		m_journal_records = make(map[string]*JournalState)
+		m_journal_pages = make(map[string]*JournalState)
+		m_journal_contents = make(map[string]string)
+	}
+	defer func() {
+		dir_data_directory = directory
+		reset()
+	}()
+	dir_data_directory = t.TempDir()
+	reset()
+
+	newRecord := func(url string, checksum string) ResultData {
+		urlChecksum := Sha256(url)
+		dataDir := filepath.Join(dir_data_directory, urlChecksum)
+		if err := os.MkdirAll(filepath.Join(dataDir, "pages"), 0750); err != nil {
+			t.Fatal(err)
+		}
+		rd := ResultData{
+			Identifier:  journalRecordIdentifier(urlChecksum),
+			URL:         url,
+			DataDir:     dataDir,
+			PDFChecksum: checksum,
+			RecordPath:  filepath.Join(dataDir, "record.json"),
+		}
+		if err := writeRecordJson(rd); err != nil {
+			t.Fatal(err)
+		}
+		return rd
+	}
+	owner := newRecord("https://example.com/2017/a.pdf", "same")
+	alias := newRecord("https://example.com/2022/a.pdf", "same")
+	other := newRecord("https://example.com/2022/b.pdf", "different")
+
+	tests := []struct {
+		name      string
+		rd        ResultData
+		duplicate bool
+	}{
+		{name: "first record owns the PDF", rd: owner},
+		{name: "same PDF under another URL", rd: alias, duplicate: true},
+		{name: "same PDF again", rd: alias, duplicate: true},
+		{name: "different PDF", rd: other},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rd, duplicate, err := dedupRecord(tt.rd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if duplicate != tt.duplicate {
+				t.Errorf("dedupRecord() duplicate = %v, want %v", duplicate, tt.duplicate)
+			}
+			if duplicate != (rd.AliasOf != nil) {
+				t.Errorf("Expected AliasOf to be set only for duplicates, but got %v", rd.AliasOf)
+			}
+		})
+	}
+
+	// the owner rewriting its record.json must not forget its aliases
+	if err := writeRecordJson(owner); err != nil {
+		t.Fatal(err)
+	}
+	ownerRd, err := ReadResultDataFromJson(owner.RecordPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ownerRd.Aliases) != 1 || ownerRd.Aliases[0].Identifier != alias.Identifier {
+		t.Errorf("Expected the owner to have the alias %v, but got %v", alias.Identifier, ownerRd.Aliases)
+	}
+	aliasRd, err := ReadResultDataFromJson(alias.RecordPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aliasRd.AliasOf == nil || aliasRd.AliasOf.Identifier != owner.Identifier {
+		t.Errorf("Expected the alias to point at %v, but got %v", owner.Identifier, aliasRd.AliasOf)
+	}
+	target, err := os.Readlink(filepath.Join(alias.DataDir, "pages"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("..", filepath.Base(owner.DataDir), "pages"); target != want {
+		t.Errorf("Expected the pages of the alias to link to %v, but got %v", want, target)
+	}
+
+	// the alias is compiled into a document of its own that uses the pages of the owner
+	ownerRd.Reconciliation = &Reconciliation{Declared: 3, Actual: 2}
+	if err := WriteResultDataToJson(ownerRd); err != nil {
+		t.Fatal(err)
+	}
+	document := aliasDocument(context.Background(), aliasRd)
+	if document.Identifier != alias.Identifier || document.URL != alias.URL || len(document.Pages) != 0 {
+		t.Errorf("Expected a document for the alias %v without pages, but got %v", alias.Identifier, document)
+	}
+	if want := journalPageIdentifier(owner.Identifier, 1); document.TotalPages != 2 || document.CoverPageIdentifier != want {
+		t.Errorf("Expected the 2 pages of the owner with %v as the cover, but got %d pages with %v as the cover", want, document.TotalPages, document.CoverPageIdentifier)
+	}
+
+	// a refresh that changes the PDF of the owner resets its aliases so they are deduplicated again
+	journalRecordStage(alias, c_journal_record_compiled)
+	if err := os.WriteFile(filepath.Join(alias.DataDir, "record.sql"), []byte("-- stale"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := resetRecordAliases(owner.RecordPath); err != nil {
+		t.Fatal(err)
+	}
+	journalResetRecord(owner.Identifier)
+	if journalRecordHasStage(alias.Identifier, c_journal_record_compiled) {
+		t.Errorf("Expected the alias %v to no longer be compiled", alias.Identifier)
+	}
+	if _, err := os.Lstat(filepath.Join(alias.DataDir, "pages")); !os.IsNotExist(err) {
+		t.Errorf("Expected the pages link of the alias to be removed, but got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(alias.DataDir, "record.sql")); !os.IsNotExist(err) {
+		t.Errorf("Expected the record.sql of the alias to be removed, but got %v", err)
+	}
+	if aliasRd, err = ReadResultDataFromJson(alias.RecordPath); err != nil || aliasRd.AliasOf != nil {
+		t.Errorf("Expected the alias to no longer point at the owner, but got %v (%v)", aliasRd.AliasOf, err)
+	}
+	if ownerRd, err = ReadResultDataFromJson(owner.RecordPath); err != nil || len(ownerRd.Aliases) != 0 {
+		t.Errorf("Expected the owner to have no aliases, but got %v (%v)", ownerRd.Aliases, err)
+	}
+	if _, duplicate, err := dedupRecord(alias); err != nil || duplicate {
+		t.Errorf("Expected the alias to own its PDF again, but got duplicate = %v (%v)", duplicate, err)
+	}
+}
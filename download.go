@@ -0,0 +1,298 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`encoding/json`
+	`errors`
+	`fmt`
+	`io`
+	`log`
+	`net`
+	`net/http`
+	`os`
+	`path/filepath`
+	`strconv`
+	`sync`
+	`time`
+)
+
+const (
+	c_download_not_found    = "not_found"
+	c_download_rate_limited = "rate_limited"
+	c_download_server_error = "server_error"
+	c_download_client_error = "client_error"
+	c_download_incomplete   = "incomplete"
+	c_download_timeout      = "timeout"
+	c_download_network      = "network"
+	c_download_checksum     = "checksum"
+	c_download_canceled     = "canceled"
+	c_download_not_modified = "not_modified"
+	c_download_failed       = "failed"
+
+	c_retry_max_backoff = 5 * time.Minute
+)
+
+var (
+	errIncompleteDownload = errors.New("incomplete download")
+	errChecksumMismatch   = errors.New("checksum mismatch")
+	errNotModified        = errors.New("not modified")
+)
+
+// DownloadError is returned by tryDownloadFile when the server answers with a status that is not a PDF.
+type DownloadError struct {
+	URL        string
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *DownloadError) Error() string {
+	return fmt.Sprintf("unexpected status %d %v when downloading %v", e.StatusCode, http.StatusText(e.StatusCode), e.URL)
+}
+
+// DownloadCache is saved as download.json next to the record.json so that a -refresh run can ask the server whether the
+// PDF changed since it was downloaded.
+type DownloadCache struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	CheckedAt    time.Time `json:"checked_at"`
+}
+
+// HostLimiter spaces out the requests made to a single host so that no more than -rate-limit requests per second are
+// started, regardless of how many downloads sem_download allows at once.
+type HostLimiter struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// classifyDownloadError returns the class of the failure, whether another attempt could succeed and how long the server
+// asked us to wait before that attempt.
+func classifyDownloadError(err error) (class string, retryable bool, wait time.Duration) {
+	var downloadErr *DownloadError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return c_download_canceled, false, 0
+	case errors.Is(err, errNotModified):
+		return c_download_not_modified, false, 0
+	case errors.As(err, &downloadErr):
+		switch {
+		case downloadErr.StatusCode == http.StatusNotFound || downloadErr.StatusCode == http.StatusGone:
+			return c_download_not_found, false, 0
+		case downloadErr.StatusCode == http.StatusTooManyRequests:
+			return c_download_rate_limited, true, downloadErr.RetryAfter
+		case downloadErr.StatusCode == http.StatusRequestTimeout || downloadErr.StatusCode >= 500:
+			return c_download_server_error, true, downloadErr.RetryAfter
+		default:
+			return c_download_client_error, false, 0
+		}
+	case errors.Is(err, errChecksumMismatch):
+		return c_download_checksum, false, 0
+	case errors.Is(err, errIncompleteDownload), errors.Is(err, io.ErrUnexpectedEOF):
+		return c_download_incomplete, true, 0
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return c_download_timeout, true, 0
+	case errors.As(err, &netErr):
+		return c_download_network, true, 0
+	}
+	return c_download_failed, false, 0
+}
+
+// retryAfter parses the Retry-After header, which is either a number of seconds or an HTTP date.
+func retryAfter(header string, now time.Time) time.Duration {
+	if len(header) == 0 {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// retryBackoff is the random exponential backoff of the attempt, unless the server asked for a longer wait.
+func retryBackoff(attempt int, wait time.Duration) time.Duration {
+	if attempt > 8 {
+		attempt = 8
+	}
+	jitter, _ := cryptoRandInt(0, 1<<attempt)
+	backoff := time.Duration(jitter) * time.Second
+	if wait > backoff {
+		backoff = wait
+	}
+	if backoff > c_retry_max_backoff {
+		backoff = c_retry_max_backoff
+	}
+	return backoff
+}
+
+// downloadClient returns the http.Client used for every download, configured from the -download-timeout flag.
+func downloadClient() *http.Client {
+	once_http_client.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.ResponseHeaderTimeout = time.Minute
+		http_client = &http.Client{
+			Timeout:   *flag_d_download_timeout,
+			Transport: transport,
+		}
+	})
+	return http_client
+}
+
+// rateLimitHost blocks until the host may receive another request according to -rate-limit, or until the ctx is done.
+func rateLimitHost(ctx context.Context, host string) error {
+	if *flag_f_rate_limit <= 0 {
+		return nil
+	}
+	interval := time.Duration(float64(time.Second) / *flag_f_rate_limit)
+
+	ihl, _ := sm_host_limiters.LoadOrStore(host, &HostLimiter{})
+	hl := ihl.(*HostLimiter)
+	hl.mu.Lock()
+	now := time.Now()
+	at := hl.next
+	if at.Before(now) {
+		at = now
+	}
+	hl.next = at.Add(interval)
+	hl.mu.Unlock()
+
+	select {
+	case <-time.After(at.Sub(now)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// delayHost pushes back every request to the host after it responded with a Retry-After.
+func delayHost(host string, wait time.Duration) {
+	if wait <= 0 {
+		return
+	}
+	ihl, _ := sm_host_limiters.LoadOrStore(host, &HostLimiter{})
+	hl := ihl.(*HostLimiter)
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+	if at := time.Now().Add(wait); at.After(hl.next) {
+		hl.next = at
+	}
+}
+
+func loadDownloadCache(path string) (DownloadCache, error) {
+	var cache DownloadCache
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return cache, err
+	}
+	err = json.Unmarshal(contents, &cache)
+	return cache, err
+}
+
+func writeDownloadCache(path string, cache DownloadCache) error {
+	contents, err := json.MarshalIndent(cache, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, contents, 0644)
+}
+
+// refreshPdf makes a conditional request for a PDF that was already downloaded and replaces it when the server has a
+// new version, returning true when the contents of the PDF changed. The refresh is downloaded next to the PDF first, so
+// a normalized PDF is only replaced when the download differs from the original.pdf that normalizePdf kept.
+func refreshPdf(ctx context.Context, url string, pdfPath string, cachePath string, checksum string) (bool, error) {
+	cache, cacheErr := loadDownloadCache(cachePath)
+	if cacheErr != nil && !os.IsNotExist(cacheErr) {
+		log.Printf("ignoring the download cache %v due to error %v", cachePath, cacheErr)
+	}
+
+	// the PDF may have been rewritten by normalizePdf, so the download is compared with the original.pdf it kept
+	downloadedPath := downloadedPdfPath(pdfPath)
+	previous, err := fileChecksum(downloadedPath)
+	if err != nil {
+		return false, err
+	}
+
+	refreshPath := pdfPath + c_refresh_download_ext
+	cache, err = downloadFile(ctx, url, refreshPath, checksum, cache)
+	if errors.Is(err, errNotModified) {
+		log.Printf("refresh: %v has not been modified since %v", url, cache.CheckedAt)
+		cache.URL = url
+		cache.CheckedAt = time.Now().UTC()
+		return false, writeDownloadCache(cachePath, cache)
+	} else if err != nil {
+		return false, err
+	}
+	err = writeDownloadCache(cachePath, cache)
+	if err != nil {
+		return false, err
+	}
+
+	current, err := fileChecksum(refreshPath)
+	if err != nil {
+		return false, err
+	}
+	if current == previous {
+		log.Printf("refresh: %v was downloaded again but has not changed", url)
+		return false, os.Remove(refreshPath)
+	}
+	log.Printf("refresh: %v changed from %v to %v", url, previous, current)
+	if downloadedPath != pdfPath {
+		err = os.Remove(downloadedPath)
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, os.Rename(refreshPath, pdfPath)
+}
+
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return FileSha512(file), nil
+}
+
+// resetRecordDir removes everything that the pipeline generated for a record whose PDF changed, keeping the files named
+// in keep.
+func resetRecordDir(recordDir string, keep ...string) error {
+	entries, err := os.ReadDir(recordDir)
+	if err != nil {
+		return err
+	}
+	kept := make(map[string]struct{}, len(keep))
+	for _, name := range keep {
+		kept[name] = struct{}{}
+	}
+	for _, entry := range entries {
+		if _, found := kept[entry.Name()]; found {
+			continue
+		}
+		err = os.RemoveAll(filepath.Join(recordDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
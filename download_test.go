@@ -0,0 +1,257 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`fmt`
+	`io`
+	`net/http`
+	`net/http/httptest`
+	`os`
+	`path/filepath`
+	`sync/atomic`
+	`testing`
+	`time`
+)
+
+func Test_classifyDownloadError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		class     string
+		retryable bool
+		wait      time.Duration
+	}{
+		{name: "not found", err: &DownloadError{StatusCode: http.StatusNotFound}, class: c_download_not_found},
+		{name: "gone", err: &DownloadError{StatusCode: http.StatusGone}, class: c_download_not_found},
+		{name: "too many requests", err: &DownloadError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Minute}, class: c_download_rate_limited, retryable: true, wait: time.Minute},
+		{name: "unavailable", err: &DownloadError{StatusCode: http.StatusServiceUnavailable}, class: c_download_server_error, retryable: true},
+		{name: "forbidden", err: &DownloadError{StatusCode: http.StatusForbidden}, class: c_download_client_error},
+		{name: "incomplete", err: fmt.Errorf("%w: received 1 of 2 bytes", errIncompleteDownload), class: c_download_incomplete, retryable: true},
+		{name: "unexpected eof", err: io.ErrUnexpectedEOF, class: c_download_incomplete, retryable: true},
+		{name: "checksum", err: fmt.Errorf("%w: expected abc", errChecksumMismatch), class: c_download_checksum},
+		{name: "deadline", err: context.DeadlineExceeded, class: c_download_timeout, retryable: true},
+		{name: "canceled", err: context.Canceled, class: c_download_canceled},
+		{name: "other", err: fmt.Errorf("disk full"), class: c_download_failed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			class, retryable, wait := classifyDownloadError(tt.err)
+			if class != tt.class || retryable != tt.retryable || wait != tt.wait {
+				t.Errorf("classifyDownloadError() = %v, %v, %v, want %v, %v, %v", class, retryable, wait, tt.class, tt.retryable, tt.wait)
+			}
+		})
+	}
+}
+
+func Test_retryAfter(t *testing.T) {
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{header: "", want: 0},
+		{header: "120", want: 2 * time.Minute},
+		{header: "Sun, 01 Oct 2023 12:00:30 GMT", want: 30 * time.Second},
+		{header: "Sun, 01 Oct 2023 11:00:00 GMT", want: 0},
+		{header: "soon", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := retryAfter(tt.header, now); got != tt.want {
+				t.Errorf("retryAfter(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_downloadFileRetryAfter(t *testing.T) {
+	rateLimit := *flag_f_rate_limit
+	defer func() {
+		*flag_f_rate_limit = rateLimit
+	}()
+	*flag_f_rate_limit = 20
+
+	var requests atomic.Int32
+	var userAgent atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent.Store(r.Header.Get("User-Agent"))
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte("%PDF-1.4"))
+	}))
+	defer server.Close()
+
+	output := filepath.Join(t.TempDir(), "file.pdf")
+	startedAt := time.Now()
+	if _, err := downloadFile(context.Background(), server.URL+"/file.pdf", output, "", DownloadCache{}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(startedAt); elapsed < time.Second {
+		t.Errorf("Expected the Retry-After of 1 second to be honored, but the download finished in %v", elapsed)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("Expected 2 requests, but got %d", got)
+	}
+	if got := userAgent.Load(); got != *flag_s_user_agent {
+		t.Errorf("Expected the User-Agent %v, but got %v", *flag_s_user_agent, got)
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Errorf("Expected %v to be downloaded, but got %v", output, err)
+	}
+}
+
+func Test_rateLimitHost(t *testing.T) {
+	rateLimit := *flag_f_rate_limit
+	defer func() {
+		*flag_f_rate_limit = rateLimit
+	}()
+	*flag_f_rate_limit = 10
+
+	startedAt := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := rateLimitHost(context.Background(), "rate.limit.test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(startedAt); elapsed < 300*time.Millisecond {
+		t.Errorf("Expected 4 requests at 10 per second to take at least 300ms, but took %v", elapsed)
+	}
+	if err := rateLimitHost(context.Background(), "other.host.test"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(startedAt); elapsed > time.Second {
+		t.Errorf("Expected another host to not wait, but took %v", elapsed)
+	}
+}
+
+func Test_refreshPdf(t *testing.T) {
+	rateLimit := *flag_f_rate_limit
+	defer func() {
+		*flag_f_rate_limit = rateLimit
+	}()
+	*flag_f_rate_limit = 0
+
+	var content atomic.Value
+	content.Store("%PDF-1.4 first")
+	var conditional atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := content.Load().(string)
+		etag := `"` + Sha256(body) + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			conditional.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	pdfPath, cachePath := filepath.Join(dir, "file.pdf"), filepath.Join(dir, "download.json")
+	cache, err := downloadFile(context.Background(), server.URL, pdfPath, "", DownloadCache{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = writeDownloadCache(cachePath, cache); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		content     string
+		changed     bool
+		conditional int32
+	}{
+		{name: "not modified", content: "%PDF-1.4 first", conditional: 1},
+		{name: "republished", content: "%PDF-1.4 second", changed: true, conditional: 1},
+		{name: "not modified after republishing", content: "%PDF-1.4 second", conditional: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content.Store(tt.content)
+			changed, err := refreshPdf(context.Background(), server.URL, pdfPath, cachePath, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tt.changed {
+				t.Errorf("refreshPdf() = %v, want %v", changed, tt.changed)
+			}
+			if got := conditional.Load(); got != tt.conditional {
+				t.Errorf("Expected %d not modified responses, but got %d", tt.conditional, got)
+			}
+			if got, _ := os.ReadFile(pdfPath); string(got) != tt.content {
+				t.Errorf("Expected the PDF to contain %q, but got %q", tt.content, got)
+			}
+		})
+	}
+}
+
+func Test_refreshPdfKeepsNormalizedPdf(t *testing.T) {
+	rateLimit := *flag_f_rate_limit
+	defer func() {
+		*flag_f_rate_limit = rateLimit
+	}()
+	*flag_f_rate_limit = 0
+
+	var content atomic.Value
+	content.Store("%PDF-1.4 first")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// no validators, so every refresh downloads the PDF again
+		_, _ = w.Write([]byte(content.Load().(string)))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	pdfPath, cachePath := filepath.Join(dir, "file.pdf"), filepath.Join(dir, "download.json")
+	originalPath := filepath.Join(dir, c_original_pdf)
+	if err := os.WriteFile(originalPath, []byte("%PDF-1.4 first"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pdfPath, []byte("%PDF-1.4 normalized"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := refreshPdf(context.Background(), server.URL, pdfPath, cachePath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(pdfPath); changed || string(got) != "%PDF-1.4 normalized" {
+		t.Errorf("Expected the normalized PDF to be kept when the download did not change, but got %v and %q", changed, got)
+	}
+	if _, err = os.Stat(pdfPath + c_refresh_download_ext); !os.IsNotExist(err) {
+		t.Errorf("Expected the refresh download to be removed, but got %v", err)
+	}
+
+	content.Store("%PDF-1.4 second")
+	changed, err = refreshPdf(context.Background(), server.URL, pdfPath, cachePath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(pdfPath); !changed || string(got) != "%PDF-1.4 second" {
+		t.Errorf("Expected the new PDF to replace the normalized PDF, but got %v and %q", changed, got)
+	}
+	if _, err = os.Stat(originalPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the original.pdf of the previous version to be removed, but got %v", err)
+	}
+}
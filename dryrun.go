@@ -0,0 +1,126 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`fmt`
+	`io`
+	`log`
+	`os`
+	`path/filepath`
+)
+
+const (
+	c_dry_run_download = "download"
+	c_dry_run_copy     = "copy"
+	c_dry_run_present  = "present"
+	c_dry_run_compiled = "compiled"
+)
+
+// DryRunPlan is what the -dry-run found in the -file, grouped by what a real run would do with each row.
+type DryRunPlan struct {
+	Download   []string
+	Copy       []string
+	Present    []string
+	Compiled   []string
+	Rejected   []string
+	TotalPages int64
+}
+
+// dryRun loads the -file through planRecord instead of processRecord and writes the plan to w. Nothing is downloaded,
+// no binaries are run and nothing is written into the -dir.
+func dryRun(ctx context.Context, filename string, w io.Writer) error {
+	a_b_dry_run.Store(true)
+	defer a_b_dry_run.Store(false)
+
+	ctx, err := importContext(ctx, filename)
+	if err != nil {
+		return err
+	}
+	// the journal decides which records a real run skips as compiled, but it is only read and never appended to
+	err = replayJournal(filepath.Join(dir_data_directory, "journal.jsonl"))
+	if err != nil {
+		return err
+	}
+	mu_dry_run.Lock()
+	plan_dry_run = DryRunPlan{}
+	mu_dry_run.Unlock()
+
+	err = loadFile(ctx, filename, dryRunRecord)
+	if err != nil {
+		return err
+	}
+
+	mu_dry_run.Lock()
+	defer mu_dry_run.Unlock()
+	plan := plan_dry_run
+	fmt.Fprintf(w, "Dry run of %v into %v\n", filename, dir_data_directory)
+	fmt.Fprintf(w, "  %d records to download\n", len(plan.Download))
+	fmt.Fprintf(w, "  %d records to copy from disk\n", len(plan.Copy))
+	fmt.Fprintf(w, "  %d records already downloaded\n", len(plan.Present))
+	fmt.Fprintf(w, "  %d records already compiled\n", len(plan.Compiled))
+	fmt.Fprintf(w, "  %d expected pages\n", plan.TotalPages)
+	fmt.Fprintf(w, "  %d rows will fail\n", len(plan.Rejected))
+	for _, rejected := range plan.Rejected {
+		fmt.Fprintf(w, "    %v\n", rejected)
+	}
+	return nil
+}
+
+// dryRunRecord is the CallbackFunc of the -dry-run. Records are compiled when the journal has their compiled stage,
+// present when their PDF is already in the -dir and otherwise copied from their local_path or downloaded.
+func dryRunRecord(ctx context.Context, row []Column) error {
+	plan, err := planRecord(ctx, row)
+	if err != nil {
+		return err
+	}
+
+	status := c_dry_run_download
+	identifier, journaled := journalLookupRecordIdentifier(plan.URLChecksum)
+	if journaled && journalRecordHasStage(identifier, c_journal_record_compiled) {
+		status = c_dry_run_compiled
+	} else if _, statErr := os.Stat(plan.PDFPath); statErr == nil {
+		status = c_dry_run_present
+	} else if len(plan.LocalPath) > 0 {
+		status = c_dry_run_copy
+	}
+	log.Printf("dry run: row %v will %v (%d pages) %v into %v", rowNumber(row), status, plan.TotalPages, plan.URL, plan.RecordDir)
+
+	mu_dry_run.Lock()
+	defer mu_dry_run.Unlock()
+	plan_dry_run.TotalPages += plan.TotalPages
+	switch status {
+	case c_dry_run_compiled:
+		plan_dry_run.Compiled = append(plan_dry_run.Compiled, plan.URL)
+	case c_dry_run_present:
+		plan_dry_run.Present = append(plan_dry_run.Present, plan.URL)
+	case c_dry_run_copy:
+		plan_dry_run.Copy = append(plan_dry_run.Copy, plan.URL)
+	default:
+		plan_dry_run.Download = append(plan_dry_run.Download, plan.URL)
+	}
+	return nil
+}
+
+// dryRunReject is called by rejectRow during a -dry-run so the rows that would fail are part of the plan.
+func dryRunReject(filename string, rowNumber int, reason string, reasonErr error) {
+	mu_dry_run.Lock()
+	defer mu_dry_run.Unlock()
+	plan_dry_run.Rejected = append(plan_dry_run.Rejected, fmt.Sprintf("%v row %d (%v): %v", filepath.Base(filename), rowNumber, reason, reasonErr))
+}
@@ -0,0 +1,104 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`bytes`
+	`context`
+	`encoding/json`
+	`os`
+	`path/filepath`
+	`strings`
+	`testing`
+)
+
+func Test_dryRun(t *testing.T) {
+	directory := dir_data_directory
+	defer func() {
+		dir_data_directory = directory
+	}()
+	reset := func() {
+		m_journal_checksums = make(map[string]string)
+		m_journal_records = make(map[string]*JournalState)
+		m_journal_pages = make(map[string]*JournalState)
+		m_journal_contents = make(map[string]string)
+	}
+	defer reset()
+	reset()
+	dir_data_directory = t.TempDir()
+
+	// a is compiled according to the journal, while the record.sql that e left behind does not make it compiled
+	compiled := Sha256("https://example.com/a.pdf")
+	var journal bytes.Buffer
+	for _, entry := range []JournalEntry{
+		{Kind: c_journal_kind_record, Key: compiled, Identifier: "2023COMPILED", Stage: c_journal_stage_assigned},
+		{Kind: c_journal_kind_record, Key: "2023COMPILED", Identifier: "2023COMPILED", Stage: c_journal_record_compiled},
+	} {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		journal.Write(append(line, '\n'))
+	}
+	presentDir := filepath.Join(dir_data_directory, Sha256("https://example.com/b.pdf"))
+	for path, contents := range map[string]string{
+		filepath.Join(dir_data_directory, "journal.jsonl"):                                   journal.String(),
+		filepath.Join(dir_data_directory, Sha256("https://example.com/e.pdf"), "record.sql"): "BEGIN;",
+		filepath.Join(presentDir, "b.pdf"):                                                   "%PDF-1.4",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	input := filepath.Join(t.TempDir(), "records.csv")
+	contents := "filename,pdf_url,page_count,creation_date,local_path\n" +
+		"a.pdf,https://example.com/a.pdf,1,,\n" +
+		"b.pdf,https://example.com/b.pdf,2,,\n" +
+		"c.pdf,https://example.com/c.pdf,3,01/02/2006,\n" +
+		"d.pdf,d.pdf,4,,\n" +
+		"e.pdf,https://example.com/e.pdf,5,00/00/0000,\n" +
+		"f.pdf,,6,,/archive/f.pdf\n"
+	if err := os.WriteFile(input, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := dryRun(context.Background(), input, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"2 records to download",
+		"1 records to copy from disk",
+		"1 records already downloaded",
+		"1 records already compiled",
+		"17 expected pages",
+		"1 rows will fail",
+		"records.csv row 5 (missing_url)",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected the plan to contain %q, but got:\n%v", want, out.String())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir_data_directory, Sha256("https://example.com/c.pdf"))); !os.IsNotExist(err) {
+		t.Errorf("Expected the dry run to not create a record directory, but got %v", err)
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	`net/http`
 	"os"
 	`os/exec`
 	"os/signal"
@@ -62,10 +63,28 @@ func main() {
 		log.Fatalf("failed to parse config.yaml due to err: %v", configErr)
 	}
 
-	binaryErr := verifyBinaries(sl_required_binaries)
-	if binaryErr != nil {
-		fmt.Printf("Error: %s\n", binaryErr)
-		os.Exit(1)
+	_, dialectErr := sqlDialect(*flag_s_sql_dialect)
+	if dialectErr != nil {
+		log.Fatalf("invalid -sql-dialect flag: %v", dialectErr)
+	}
+
+	if len(*flag_s_serve_mirror) > 0 {
+		log.Printf("serving the mirror %v on http://%v", *flag_s_mirror, *flag_s_serve_mirror)
+		log.Fatal(http.ListenAndServe(*flag_s_serve_mirror, fixtureHandler(*flag_s_mirror)))
+	}
+
+	if !*flag_b_dry_run {
+		binaryErr := verifyBinaries(sl_required_binaries)
+		if binaryErr != nil {
+			fmt.Printf("Error: %s\n", binaryErr)
+			os.Exit(1)
+		}
+		for _, binary := range sl_optional_binaries {
+			optionalErr := verifyBinaries([]string{binary})
+			if optionalErr != nil {
+				log.Printf("skipping the optional binary %v due to error %v", binary, optionalErr)
+			}
+		}
 	}
 
 	ex, execErr := os.Getwd()
@@ -74,7 +93,7 @@ func main() {
 	}
 
 	dir_current_directory = filepath.Dir(ex)
-	fmt.Sprintf("Current Working Directory: %s\n", dir_current_directory)
+	log.Printf("Current Working Directory: %s\n", dir_current_directory)
 
 	if *flag_s_file == "" || *flag_s_directory == "" {
 		flag.Usage()
@@ -105,15 +124,38 @@ func main() {
 	}
 	log.SetOutput(logFile)
 
+	if *flag_b_dry_run {
+		dryRunErr := dryRun(ctx, *flag_s_file, os.Stdout)
+		logFile.Close()
+		if dryRunErr != nil {
+			fmt.Printf("Error: %s\n", dryRunErr)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	journalErr := loadJournal(filepath.Join(dir_data_directory, "journal.jsonl"))
+	if journalErr != nil {
+		log.Fatalf("failed to load the journal from %v due to error %v", dir_data_directory, journalErr)
+	}
+
+	rejectedErr := loadRejected(filepath.Join(dir_data_directory, "rejected.csv"))
+	if rejectedErr != nil {
+		log.Fatalf("failed to create the rejected rows report in %v due to error %v", dir_data_directory, rejectedErr)
+	}
+
 	watchdog := make(chan os.Signal, 1)
 	signal.Notify(watchdog, os.Kill, syscall.SIGTERM, os.Interrupt)
 	go func() {
 		<-watchdog
+		closeJournal()
+		closeRejected()
 		err := logFile.Close()
 		if err != nil {
 			log.Printf("failed to close the logFile due to error: %v", err)
 		}
 		cancel()
+		killRunningTools()
 
 		wg_active_tasks.PreventAdd()
 
@@ -178,7 +220,10 @@ func main() {
 		log.Printf("Cryptonyms to search for: %v", out)
 	}
 
-	ctx = context.WithValue(ctx, CtxKey("filename"), *flag_s_file)
+	ctx, importCtxErr := importContext(ctx, *flag_s_file)
+	if importCtxErr != nil {
+		log.Fatalf("failed to load the metadata profile due to error %v", importCtxErr)
+	}
 
 	go receiveImportedRow(ctx, ch_ImportedRow.Chan())             // step 01 - runs validatePdf before sending into ch_ExtractText
 	go receiveOnExtractTextCh(ctx, ch_ExtractText.Chan())         // step 02 - runs extractPlainTextFromPdf before sending into ch_ExtractPages
@@ -193,14 +238,16 @@ func main() {
 	go receiveAnalyzeLocations(ctx, ch_AnalyzeLocations.Chan())   // step 11 - runs analyzeLocations before sending PendingPage into ch_AnalyzeGematria
 	go receiveAnalyzeGematria(ctx, ch_AnalyzeGematria.Chan())     // step 12 - runs analyzeGematria before sending PendingPage into ch_AnalyzeDictionary
 	go receiveAnalyzeDictionary(ctx, ch_AnalyzeDictionary.Chan()) // step 13 - runs analyzeWordIndexer before sending PendingPage into ch_CompletedPage
-	go receiveCompletedPendingPage(ctx, ch_CompletedPage.Chan())  // step 14 - compiles a final result of a Document before sending it into ch_CompiledDocument
+	go receiveCompletedPendingPage(ctx, ch_CompletedPage.Chan())  // step 14 - runs aggregatePendingPage and sends one Document per record into ch_CompiledDocument
 	go receiveCompiledDocument(ctx, ch_CompiledDocument.Chan())   // step 15 - compiles the SQL insert statements for the Document
 
 	go func() {
 		wg_active_tasks.Add(1)
 		defer wg_active_tasks.Done()
 
-		locationsCsvErr := loadCsv(ctx, *flag_s_locations_file, processLocation)
+		// the -locations-file is a reference file, its skipped rows are not rejected rows of the -file
+		locationsCtx := context.WithValue(ctx, CtxKey("reject"), RejectFunc(ignoreRejectedRow))
+		locationsCsvErr := loadCsv(locationsCtx, *flag_s_locations_file, processLocation)
 		if locationsCsvErr != nil {
 			log.Printf("received an error from loadCsv/loadXlsx namely: %v", locationsCsvErr) // a problem habbened
 			return
@@ -209,15 +256,7 @@ func main() {
 		a_b_locations_loaded.Store(true)
 	}()
 
-	var importErr error
-	if strings.Contains(*flag_s_file, ".csv") || strings.Contains(*flag_s_file, ".psv") {
-		importErr = loadCsv(ctx, *flag_s_file, processRecord) // parse the file
-	} else if strings.Contains(*flag_s_file, ".xlsx") {
-		importErr = loadXlsx(ctx, *flag_s_file, processRecord) // parse the file
-	} else {
-		panic(fmt.Sprintf("unable to parse file %v", *flag_s_file))
-	}
-
+	importErr := loadFile(ctx, *flag_s_file, processRecord)
 	if importErr != nil {
 		log.Printf("received an error from loadCsv/loadXlsx namely: %v", importErr) // a problem habbened
 	}
@@ -234,15 +273,24 @@ func main() {
 		case <-ch_Done:
 			log.SetOutput(os.Stdout)
 			log.Printf("done processing everything... time to end things now!")
-			watchdog <- os.Kill
-		case id, ok := <-ch_CompiledDocument.Chan():
-			if ok {
-				d, ok := id.(Document)
-				if !ok {
-					log.Printf("cannot typecast the final result for %d as a .(Document)", d.Identifier)
-				}
-				log.Printf("Completed processing document %v", d.Identifier)
+			if rejected := a_i_rejected_rows.Load(); rejected > 0 {
+				log.Printf("%d rows were rejected, see %v for the reasons", rejected, filepath.Join(dir_data_directory, "rejected.csv"))
 			}
+			qualityReport := filepath.Join(dir_data_directory, "ocr_quality.csv")
+			worstPages, qualityErr := writeOcrQualityReport(dir_data_directory, qualityReport, *flag_i_worst_pages)
+			if qualityErr != nil {
+				log.Printf("failed to write the OCR quality report %v due to error %v", qualityReport, qualityErr)
+			} else if worstPages > 0 {
+				log.Printf("the %d pages with the lowest OCR quality are listed in %v", worstPages, qualityReport)
+			}
+			reconciliationReport := filepath.Join(dir_data_directory, "reconciliation.csv")
+			mismatches, reconciliationErr := writeReconciliationReport(dir_data_directory, reconciliationReport)
+			if reconciliationErr != nil {
+				log.Printf("failed to write the page reconciliation report %v due to error %v", reconciliationReport, reconciliationErr)
+			} else if mismatches > 0 {
+				log.Printf("%d records have pages that do not match their metadata, see %v", mismatches, reconciliationReport)
+			}
+			watchdog <- os.Kill
 		}
 	}
 
@@ -0,0 +1,169 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`fmt`
+	`io`
+	`net/http`
+	`net/url`
+	`os`
+	`path`
+	`path/filepath`
+	`strings`
+	`time`
+)
+
+const c_file_url_prefix = "file://"
+
+// Fetcher acquires the PDF at a URL into the partial file that downloadFile verifies and renames. A Fetcher returns
+// errNotModified when the validators of the cache show that the PDF did not change.
+type Fetcher interface {
+	Fetch(ctx context.Context, url string, partial string, cache DownloadCache) (DownloadCache, error)
+}
+
+// HTTPFetcher downloads PDFs from the web with Range requests, conditional requests and the -rate-limit.
+type HTTPFetcher struct{}
+
+// FileFetcher copies PDFs from file:// URLs.
+type FileFetcher struct{}
+
+// MirrorFetcher copies PDFs from a local mirror of the web where a URL such as https://host/path/a.pdf is stored as
+// Dir/host/path/a.pdf, which is the layout that `wget --mirror` produces.
+type MirrorFetcher struct {
+	Dir string
+}
+
+func (f HTTPFetcher) Fetch(ctx context.Context, url string, partial string, cache DownloadCache) (DownloadCache, error) {
+	return tryDownloadFile(ctx, url, partial, cache)
+}
+
+func (f FileFetcher) Fetch(ctx context.Context, url string, partial string, cache DownloadCache) (DownloadCache, error) {
+	return fetchLocalFile(ctx, filepath.FromSlash(strings.TrimPrefix(url, c_file_url_prefix)), url, partial, cache)
+}
+
+func (f MirrorFetcher) Fetch(ctx context.Context, url string, partial string, cache DownloadCache) (DownloadCache, error) {
+	mirrored, err := mirrorPath(f.Dir, url)
+	if err != nil {
+		return cache, err
+	}
+	return fetchLocalFile(ctx, mirrored, url, partial, cache)
+}
+
+// fetcherFor returns the Fetcher for the url. Every http(s) URL is read from the -mirror instead of the web when the
+// -mirror flag is set.
+func fetcherFor(url string) Fetcher {
+	switch {
+	case strings.HasPrefix(url, c_file_url_prefix):
+		return FileFetcher{}
+	case len(*flag_s_mirror) > 0:
+		return MirrorFetcher{Dir: *flag_s_mirror}
+	default:
+		return HTTPFetcher{}
+	}
+}
+
+// isFetchableURL returns true when fetcherFor has a Fetcher that understands the url.
+func isFetchableURL(url string) bool {
+	return strings.HasPrefix(url, "http") || strings.HasPrefix(url, c_file_url_prefix)
+}
+
+// mirrorPath returns where the url is stored inside the mirror dir, which never points outside of the dir.
+func mirrorPath(dir string, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if len(u.Host) == 0 {
+		return "", fmt.Errorf("cannot find %v in the mirror %v because it does not have a host", rawURL, dir)
+	}
+	return filepath.Join(dir, u.Host, filepath.FromSlash(path.Clean("/"+u.Path))), nil
+}
+
+// fetchLocalFile copies the file at filename into the partial file. The modification time of the file is used as the
+// Last-Modified validator of the cache, and a missing file is reported the same way as a 404 from a server.
+func fetchLocalFile(ctx context.Context, filename string, url string, partial string, cache DownloadCache) (DownloadCache, error) {
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return cache, &DownloadError{URL: url, StatusCode: http.StatusNotFound}
+	} else if err != nil {
+		return cache, err
+	}
+	lastModified := info.ModTime().UTC().Format(http.TimeFormat)
+	if _, partialErr := os.Stat(partial); os.IsNotExist(partialErr) && cache.LastModified == lastModified {
+		return cache, errNotModified
+	}
+
+	in, err := os.Open(filename)
+	if err != nil {
+		return cache, err
+	}
+	defer in.Close()
+	out, err := os.Create(partial)
+	if err != nil {
+		return cache, err
+	}
+	defer out.Close()
+	written, err := io.Copy(out, &contextReader{ctx: ctx, r: in})
+	if err != nil {
+		return cache, err
+	}
+	if written != info.Size() {
+		return cache, fmt.Errorf("%w: copied %d of %d bytes of %v", errIncompleteDownload, written, info.Size(), filename)
+	}
+	return DownloadCache{
+		URL:          url,
+		LastModified: lastModified,
+		CheckedAt:    time.Now().UTC(),
+	}, nil
+}
+
+// contextReader stops a copy once the ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// fixtureHandler serves a mirror directory over HTTP so that a run, or a test, can download PDFs without a network. A
+// request for /host/path/a.pdf is answered with dir/host/path/a.pdf, including Range and conditional requests.
+func fixtureHandler(dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filename := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+		file, err := os.Open(filename)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+		http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+	})
+}
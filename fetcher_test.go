@@ -0,0 +1,108 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`errors`
+	`net/http/httptest`
+	`os`
+	`path/filepath`
+	`testing`
+)
+
+func Test_mirrorPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{name: "url", url: "https://www.archives.gov/files/research/jfk/a.pdf", want: filepath.Join("mirror", "www.archives.gov", "files", "research", "jfk", "a.pdf")},
+		{name: "query is ignored", url: "https://example.com/a.pdf?download=1", want: filepath.Join("mirror", "example.com", "a.pdf")},
+		{name: "parent directories stay inside", url: "https://example.com/../../etc/passwd", want: filepath.Join("mirror", "example.com", "etc", "passwd")},
+		{name: "no host", url: "a.pdf", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mirrorPath("mirror", tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mirrorPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("mirrorPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_downloadFileFetchers(t *testing.T) {
+	rateLimit, mirror := *flag_f_rate_limit, *flag_s_mirror
+	defer func() {
+		*flag_f_rate_limit, *flag_s_mirror = rateLimit, mirror
+	}()
+	*flag_f_rate_limit = 0
+
+	dir := t.TempDir()
+	const contents = "%PDF-1.4 fixture"
+	mirrored := filepath.Join(dir, "mirror", "www.archives.gov", "files", "a.pdf")
+	if err := os.MkdirAll(filepath.Dir(mirrored), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(mirrored, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(fixtureHandler(filepath.Join(dir, "mirror")))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		mirror  string
+		fetcher Fetcher
+	}{
+		{name: "fixture server", url: server.URL + "/www.archives.gov/files/a.pdf", fetcher: HTTPFetcher{}},
+		{name: "file url", url: c_file_url_prefix + filepath.ToSlash(mirrored), fetcher: FileFetcher{}},
+		{name: "mirror", url: "https://www.archives.gov/files/a.pdf", mirror: filepath.Join(dir, "mirror"), fetcher: MirrorFetcher{Dir: filepath.Join(dir, "mirror")}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*flag_s_mirror = tt.mirror
+			if got := fetcherFor(tt.url); got != tt.fetcher {
+				t.Fatalf("fetcherFor() = %#v, want %#v", got, tt.fetcher)
+			}
+			output := filepath.Join(dir, filepath.Base(tt.name)+".pdf")
+			cache, err := downloadFile(context.Background(), tt.url, output, Sha256(contents), DownloadCache{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := os.ReadFile(output); string(got) != contents {
+				t.Errorf("Expected %v to contain %q, but got %q", output, contents, got)
+			}
+			if _, err = downloadFile(context.Background(), tt.url, output, "", cache); !errors.Is(err, errNotModified) {
+				t.Errorf("Expected test %d to be not modified the second time, but got %v", i, err)
+			}
+		})
+	}
+
+	*flag_s_mirror = filepath.Join(dir, "mirror")
+	_, err := downloadFile(context.Background(), "https://www.archives.gov/files/missing.pdf", filepath.Join(dir, "missing.pdf"), "", DownloadCache{})
+	if class, _, _ := classifyDownloadError(err); class != c_download_not_found {
+		t.Errorf("Expected a PDF missing from the mirror to be %v, but got %v (%v)", c_download_not_found, class, err)
+	}
+}
@@ -0,0 +1,381 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`bufio`
+	`encoding/json`
+	`fmt`
+	`log`
+	`os`
+	`strings`
+	`time`
+
+	ch `github.com/andreimerlescu/go-smartchan`
+)
+
+const (
+	c_journal_kind_record  = "record"
+	c_journal_kind_page    = "page"
+	c_journal_kind_content = "content"
+
+	c_journal_stage_assigned = "assigned"
+	c_journal_stage_reset    = "reset"
+	c_journal_stage_stored   = "stored"
+
+	c_journal_record_validated       = "validated"
+	c_journal_record_text_extracted  = "text_extracted"
+	c_journal_record_pages_extracted = "pages_extracted"
+	c_journal_record_compiled        = "compiled"
+
+	c_journal_page_png        = "png"
+	c_journal_page_light      = "light"
+	c_journal_page_dark       = "dark"
+	c_journal_page_ocr        = "ocr"
+	c_journal_page_jpg        = "jpg"
+	c_journal_page_text       = "text"
+	c_journal_page_cryptonyms = "cryptonyms"
+	c_journal_page_locations  = "locations"
+	c_journal_page_gematria   = "gematria"
+	c_journal_page_dictionary = "dictionary"
+	c_journal_page_completed  = "completed"
+)
+
+// sl_journal_page_stages lists the stages of a page in the order that the pipeline runs them. Each stage is
+// received on the channel at the same index of journalPageChannels().
+var sl_journal_page_stages = []string{
+	c_journal_page_png,
+	c_journal_page_light,
+	c_journal_page_dark,
+	c_journal_page_ocr,
+	c_journal_page_jpg,
+	c_journal_page_text,
+	c_journal_page_cryptonyms,
+	c_journal_page_locations,
+	c_journal_page_gematria,
+	c_journal_page_dictionary,
+	c_journal_page_completed,
+}
+
+func journalPageChannels() []*ch.SmartChan {
+	return []*ch.SmartChan{
+		ch_GeneratePng,
+		ch_GenerateLight,
+		ch_GenerateDark,
+		ch_PerformOcr,
+		ch_ConvertToJpg,
+		ch_AnalyzeText,
+		ch_AnalyzeCryptonyms,
+		ch_AnalyzeLocations,
+		ch_AnalyzeGematria,
+		ch_AnalyzeDictionary,
+		ch_CompletedPage,
+	}
+}
+
+// JournalEntry is a single line of the journal.jsonl file. Records are keyed by the checksum of their PDF URL when an
+// identifier is assigned and by their identifier afterwards; pages are keyed by their record identifier and page number;
+// contents are keyed by the checksum of a PDF and identify the record whose pages were generated from it.
+type JournalEntry struct {
+	At         time.Time `json:"at"`
+	Kind       string    `json:"kind"`
+	Key        string    `json:"key"`
+	Identifier string    `json:"identifier"`
+	PageNumber int       `json:"page_number,omitempty"`
+	Stage      string    `json:"stage"`
+}
+
+type JournalState struct {
+	Identifier string
+	Stages     map[string]struct{}
+}
+
+// loadJournal replays the journal from a previous run into memory and opens it for appending the entries of this run.
+func loadJournal(path string) error {
+	mu_journal.Lock()
+	defer mu_journal.Unlock()
+
+	err := replayJournalLocked(path)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	file_journal = file
+	return nil
+}
+
+// replayJournal replays the journal from a previous run into memory without opening it for appending, which is what
+// the -dry-run uses to predict what a real run would skip.
+func replayJournal(path string) error {
+	mu_journal.Lock()
+	defer mu_journal.Unlock()
+	return replayJournalLocked(path)
+}
+
+func replayJournalLocked(path string) error {
+	existing, openErr := os.Open(path)
+	if os.IsNotExist(openErr) {
+		return nil
+	} else if openErr != nil {
+		return openErr
+	}
+	defer existing.Close()
+
+	replayed := 0
+	scanner := bufio.NewScanner(existing)
+	for scanner.Scan() {
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Printf("skipping journal line %q due to error %v", scanner.Text(), err)
+			continue
+		}
+		journalApply(entry)
+		replayed++
+	}
+	if scanErr := scanner.Err(); scanErr != nil {
+		return scanErr
+	}
+	log.Printf("replayed %d entries from the journal %v", replayed, path)
+	return nil
+}
+
+func closeJournal() {
+	mu_journal.Lock()
+	defer mu_journal.Unlock()
+	if file_journal == nil {
+		return
+	}
+	err := file_journal.Close()
+	if err != nil {
+		log.Printf("failed to close the journal due to error %v", err)
+	}
+	file_journal = nil
+}
+
+func journalPageKey(recordIdentifier string, pageNumber int) string {
+	return fmt.Sprintf("%v/%06d", recordIdentifier, pageNumber)
+}
+
+// journalApply updates the in-memory state with the entry, the caller must hold mu_journal.
+func journalApply(entry JournalEntry) {
+	var states map[string]*JournalState
+	var key string
+	switch entry.Kind {
+	case c_journal_kind_record:
+		if entry.Stage == c_journal_stage_assigned {
+			m_journal_checksums[entry.Key] = entry.Identifier
+		}
+		if entry.Stage == c_journal_stage_reset {
+			// the PDF of the record changed, so every stage of the record and its pages has to run again
+			delete(m_journal_records, entry.Identifier)
+			for pageKey := range m_journal_pages {
+				if strings.HasPrefix(pageKey, entry.Identifier+"/") {
+					delete(m_journal_pages, pageKey)
+				}
+			}
+			for checksum, owner := range m_journal_contents {
+				if owner == entry.Identifier {
+					delete(m_journal_contents, checksum)
+				}
+			}
+			return
+		}
+		states, key = m_journal_records, entry.Identifier
+	case c_journal_kind_page:
+		states, key = m_journal_pages, journalPageKey(entry.Key, entry.PageNumber)
+	case c_journal_kind_content:
+		m_journal_contents[entry.Key] = entry.Identifier
+		return
+	default:
+		return
+	}
+
+	state, found := states[key]
+	if !found {
+		state = &JournalState{Identifier: entry.Identifier, Stages: make(map[string]struct{})}
+		states[key] = state
+	}
+	state.Stages[entry.Stage] = struct{}{}
+
+	var seed string
+	switch entry.Kind {
+	case c_journal_kind_record:
+		if entry.Stage == c_journal_stage_assigned {
+			seed = recordIdentifierSeed(entry.Key)
+		}
+	case c_journal_kind_page:
+		seed = pageIdentifierSeed(entry.Key, entry.PageNumber)
+	}
+	mu_identifier.Lock()
+	if owner, exists := m_used_identifiers[entry.Identifier]; !exists || len(owner) == 0 {
+		m_used_identifiers[entry.Identifier] = seed
+	}
+	mu_identifier.Unlock()
+}
+
+// journalAppend applies the entry and writes it to the journal unless an identical stage was already recorded.
+func journalAppend(entry JournalEntry) {
+	mu_journal.Lock()
+	defer mu_journal.Unlock()
+	journalAppendLocked(entry)
+}
+
+func journalAppendLocked(entry JournalEntry) {
+	if journalHasStageLocked(entry) {
+		return
+	}
+	entry.At = time.Now().UTC()
+	journalApply(entry)
+	if file_journal == nil {
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("failed to encode journal entry %v due to error %v", entry, err)
+		return
+	}
+	_, err = file_journal.Write(append(line, '\n'))
+	if err != nil {
+		log.Printf("failed to write journal entry %v due to error %v", entry, err)
+	}
+}
+
+func journalHasStageLocked(entry JournalEntry) bool {
+	var state *JournalState
+	switch entry.Kind {
+	case c_journal_kind_record:
+		if entry.Stage == c_journal_stage_reset {
+			return false
+		}
+		if entry.Stage == c_journal_stage_assigned {
+			_, found := m_journal_checksums[entry.Key]
+			return found
+		}
+		state = m_journal_records[entry.Identifier]
+	case c_journal_kind_page:
+		state = m_journal_pages[journalPageKey(entry.Key, entry.PageNumber)]
+	case c_journal_kind_content:
+		_, found := m_journal_contents[entry.Key]
+		return found
+	}
+	if state == nil {
+		return false
+	}
+	_, found := state.Stages[entry.Stage]
+	return found
+}
+
+// journalRecordIdentifier returns the identifier that a previous run assigned to the PDF URL checksum, or assigns a new
+// identifier and journals it.
+func journalRecordIdentifier(urlChecksum string) string {
+	mu_journal.Lock()
+	defer mu_journal.Unlock()
+	identifier, found := m_journal_checksums[urlChecksum]
+	if found {
+		return identifier
+	}
+	identifier = NewRecordIdentifier(urlChecksum)
+	journalAppendLocked(JournalEntry{Kind: c_journal_kind_record, Key: urlChecksum, Identifier: identifier, Stage: c_journal_stage_assigned})
+	return identifier
+}
+
+// journalLookupRecordIdentifier returns the identifier that a previous run assigned to the PDF URL checksum without
+// assigning one when there is none.
+func journalLookupRecordIdentifier(urlChecksum string) (string, bool) {
+	mu_journal.Lock()
+	defer mu_journal.Unlock()
+	identifier, found := m_journal_checksums[urlChecksum]
+	return identifier, found
+}
+
+// journalPageIdentifier returns the identifier that a previous run assigned to the page of the record, or assigns a new
+// identifier and journals it.
+func journalPageIdentifier(recordIdentifier string, pageNumber int) string {
+	mu_journal.Lock()
+	defer mu_journal.Unlock()
+	state, found := m_journal_pages[journalPageKey(recordIdentifier, pageNumber)]
+	if found {
+		return state.Identifier
+	}
+	identifier := NewPageIdentifier(recordIdentifier, pageNumber)
+	journalAppendLocked(JournalEntry{Kind: c_journal_kind_page, Key: recordIdentifier, Identifier: identifier, PageNumber: pageNumber, Stage: c_journal_stage_assigned})
+	return identifier
+}
+
+// journalContentOwner returns the identifier of the record that owns the pages of the PDF checksum, making the record
+// the owner when no other record has stored that PDF before.
+func journalContentOwner(checksum string, recordIdentifier string) string {
+	mu_journal.Lock()
+	defer mu_journal.Unlock()
+	owner, found := m_journal_contents[checksum]
+	if found {
+		return owner
+	}
+	journalAppendLocked(JournalEntry{Kind: c_journal_kind_content, Key: checksum, Identifier: recordIdentifier, Stage: c_journal_stage_stored})
+	return recordIdentifier
+}
+
+// journalRecordUrlChecksum returns the checksum of the PDF URL that the record identifier was assigned to.
+func journalRecordUrlChecksum(recordIdentifier string) (string, bool) {
+	mu_journal.Lock()
+	defer mu_journal.Unlock()
+	for urlChecksum, identifier := range m_journal_checksums {
+		if identifier == recordIdentifier {
+			return urlChecksum, true
+		}
+	}
+	return "", false
+}
+
+func journalRecordStage(rd ResultData, stage string) {
+	journalAppend(JournalEntry{Kind: c_journal_kind_record, Key: rd.Identifier, Identifier: rd.Identifier, Stage: stage})
+}
+
+// journalResetRecord forgets every stage of the record and its pages so that the pipeline runs it again from the start.
+func journalResetRecord(recordIdentifier string) {
+	journalAppend(JournalEntry{Kind: c_journal_kind_record, Key: recordIdentifier, Identifier: recordIdentifier, Stage: c_journal_stage_reset})
+}
+
+func journalRecordHasStage(recordIdentifier string, stage string) bool {
+	mu_journal.Lock()
+	defer mu_journal.Unlock()
+	return journalHasStageLocked(JournalEntry{Kind: c_journal_kind_record, Identifier: recordIdentifier, Stage: stage})
+}
+
+func journalPageStage(pp PendingPage, stage string) {
+	journalAppend(JournalEntry{Kind: c_journal_kind_page, Key: pp.RecordIdentifier, Identifier: pp.Identifier, PageNumber: pp.PageNumber, Stage: stage})
+}
+
+// journalPageResumeAt returns the index into sl_journal_page_stages of the first stage the page has not completed.
+func journalPageResumeAt(recordIdentifier string, pageNumber int) int {
+	mu_journal.Lock()
+	defer mu_journal.Unlock()
+	state, found := m_journal_pages[journalPageKey(recordIdentifier, pageNumber)]
+	if !found {
+		return 0
+	}
+	for i, stage := range sl_journal_page_stages {
+		if _, done := state.Stages[stage]; !done {
+			return i
+		}
+	}
+	return len(sl_journal_page_stages)
+}
@@ -0,0 +1,82 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`path/filepath`
+	`testing`
+)
+
+func Test_loadJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	reset := func() {
+		m_journal_checksums = make(map[string]string)
+		m_journal_records = make(map[string]*JournalState)
+		m_journal_pages = make(map[string]*JournalState)
+	}
+	reset()
+	defer reset()
+
+	if err := loadJournal(path); err != nil {
+		t.Fatal(err)
+	}
+	recordIdentifier := journalRecordIdentifier("checksum")
+	pp := PendingPage{RecordIdentifier: recordIdentifier, PageNumber: 3}
+	pp.Identifier = journalPageIdentifier(recordIdentifier, pp.PageNumber)
+	journalRecordStage(ResultData{Identifier: recordIdentifier}, c_journal_record_validated)
+	for _, stage := range []string{c_journal_page_png, c_journal_page_light, c_journal_page_dark, c_journal_page_png} {
+		journalPageStage(pp, stage)
+	}
+	closeJournal()
+
+	reset()
+	if err := loadJournal(path); err != nil {
+		t.Fatal(err)
+	}
+	defer closeJournal()
+
+	if got := journalRecordIdentifier("checksum"); got != recordIdentifier {
+		t.Errorf("Expected the record identifier %v to be reused, but got %v", recordIdentifier, got)
+	}
+	if got := journalPageIdentifier(recordIdentifier, pp.PageNumber); got != pp.Identifier {
+		t.Errorf("Expected the page identifier %v to be reused, but got %v", pp.Identifier, got)
+	}
+	if !journalRecordHasStage(recordIdentifier, c_journal_record_validated) {
+		t.Errorf("Expected record %v to be validated", recordIdentifier)
+	}
+	if journalRecordHasStage(recordIdentifier, c_journal_record_compiled) {
+		t.Errorf("Expected record %v to not be compiled", recordIdentifier)
+	}
+	if got := journalPageResumeAt(recordIdentifier, pp.PageNumber); sl_journal_page_stages[got] != c_journal_page_ocr {
+		t.Errorf("Expected page %v to resume at the ocr stage, but got %v", pp.Identifier, sl_journal_page_stages[got])
+	}
+	if got := journalPageResumeAt(recordIdentifier, 4); got != 0 {
+		t.Errorf("Expected an unknown page to start at the first stage, but got %v", got)
+	}
+
+	journalResetRecord(recordIdentifier)
+	if journalRecordHasStage(recordIdentifier, c_journal_record_validated) {
+		t.Errorf("Expected record %v to forget its stages after a reset", recordIdentifier)
+	}
+	if got := journalPageResumeAt(recordIdentifier, pp.PageNumber); got != 0 {
+		t.Errorf("Expected page %v to start over after a reset, but got %v", pp.Identifier, sl_journal_page_stages[got])
+	}
+	if got := journalRecordIdentifier("checksum"); got != recordIdentifier {
+		t.Errorf("Expected the record identifier %v to survive a reset, but got %v", recordIdentifier, got)
+	}
+}
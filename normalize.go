@@ -0,0 +1,148 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`errors`
+	`fmt`
+	`log`
+	`os`
+	`os/exec`
+	`path/filepath`
+	`time`
+)
+
+const (
+	c_original_pdf      = "original.pdf"
+	c_normalize_pdf_ext = ".normalize.pdf"
+
+	c_repair_none            = "none"
+	c_repair_pdfcpu_optimize = "pdfcpu_optimize"
+	c_repair_gs_rewrite      = "gs_rewrite"
+	c_repair_qpdf            = "qpdf"
+)
+
+// PDFRepair rewrites the input PDF into the output PDF. Optional repairs are skipped when their binary is not installed
+// and a repair without a Binary runs in-process.
+type PDFRepair struct {
+	Name     string
+	Binary   string
+	Optional bool
+	Run      func(ctx context.Context, input string, output string) error
+}
+
+// sl_pdf_repairs are tried in order by normalizePdf until one of them writes a PDF that passes validatePdfFile.
+var sl_pdf_repairs = []PDFRepair{
+	{
+		Name: c_repair_pdfcpu_optimize,
+		Run:  optimizePdfFile,
+	},
+	{
+		Name:   c_repair_gs_rewrite,
+		Binary: "gs",
+		Run: func(ctx context.Context, input string, output string) error {
+			_, err := runTool(ctx, ToolCommand{Name: "gs", Args: []string{"-q", "-dNOPAUSE", "-dBATCH", "-dSAFER", "-sDEVICE=pdfwrite", "-dCompatibilityLevel=1.7", "-o", output, input}, Semaphore: sem_gs})
+			return err
+		},
+	},
+	{
+		// qpdf rebuilds the cross reference table of a damaged PDF, which is what rescues most truncated scans
+		Name:     c_repair_qpdf,
+		Binary:   "qpdf",
+		Optional: true,
+		Run: func(ctx context.Context, input string, output string) error {
+			_, err := runTool(ctx, ToolCommand{Name: "qpdf", Args: []string{input, output}, Semaphore: sem_qpdf})
+			// qpdf exits with 3 when it recovered the file with warnings
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) && exitErr.ExitCode() == 3 {
+				return nil
+			}
+			return err
+		},
+	},
+}
+
+// normalizePdf keeps the downloaded PDF as original.pdf in the record directory and replaces the record's PDFPath with
+// the output of the first of the sl_pdf_repairs that passes validation. Every repair writes into a temp file so that
+// the original is never overwritten. When no repair works but the original is valid, the original is used as is.
+func normalizePdf(ctx context.Context, record ResultData) (PDFNormalization, error) {
+	normalization := PDFNormalization{OriginalPath: filepath.Join(record.DataDir, c_original_pdf)}
+	if _, statErr := os.Stat(normalization.OriginalPath); os.IsNotExist(statErr) {
+		err := copyFile(record.PDFPath, normalization.OriginalPath)
+		if err != nil {
+			return normalization, err
+		}
+	}
+
+	temp := record.PDFPath + c_normalize_pdf_ext
+	defer os.Remove(temp)
+	originalInfo, originalErr := validatePdfFile(ctx, normalization.OriginalPath)
+	for _, repair := range sl_pdf_repairs {
+		if ctx.Err() != nil {
+			return normalization, ctx.Err()
+		}
+		if repair.Optional && len(m_required_binaries[repair.Binary]) == 0 {
+			continue
+		}
+		removeErr := os.Remove(temp)
+		if removeErr != nil && !os.IsNotExist(removeErr) {
+			return normalization, removeErr
+		}
+		var info PDFInfo
+		err := repair.Run(ctx, normalization.OriginalPath, temp)
+		if err == nil {
+			info, err = validatePdfFile(ctx, temp)
+		}
+		if err != nil {
+			log.Printf("the %v repair of %v did not produce a valid PDF due to error %v", repair.Name, record.PDFPath, err)
+			normalization.Failed = append(normalization.Failed, fmt.Sprintf("%v: %v", repair.Name, err))
+			continue
+		}
+		err = os.Rename(temp, record.PDFPath)
+		if err != nil {
+			return normalization, err
+		}
+		normalization.Repair = repair.Name
+		normalization.PageCount = info.PageCount
+		normalization.NormalizedAt = time.Now().UTC()
+		return normalization, nil
+	}
+
+	if originalErr != nil {
+		return normalization, fmt.Errorf("none of the repairs of %v produced a valid PDF and the original failed validation due to error %v", record.PDFPath, originalErr)
+	}
+	err := copyFile(normalization.OriginalPath, record.PDFPath)
+	if err != nil {
+		return normalization, err
+	}
+	normalization.Repair = c_repair_none
+	normalization.PageCount = originalInfo.PageCount
+	normalization.NormalizedAt = time.Now().UTC()
+	return normalization, nil
+}
+
+// downloadedPdfPath returns the original.pdf that normalizePdf kept for the PDF, or the PDF itself before it was
+// normalized.
+func downloadedPdfPath(pdfPath string) string {
+	originalPath := filepath.Join(filepath.Dir(pdfPath), c_original_pdf)
+	if _, err := os.Stat(originalPath); err == nil {
+		return originalPath
+	}
+	return pdfPath
+}
@@ -0,0 +1,188 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`bufio`
+	`fmt`
+	`image`
+	_ `image/jpeg`
+	_ `image/png`
+	`io`
+	`log`
+	`math`
+	`os`
+	`strconv`
+	`strings`
+)
+
+const (
+	c_image_original = "original"
+	c_image_large    = "large"
+	c_image_medium   = "medium"
+	c_image_small    = "small"
+	c_image_social   = "social"
+
+	// c_tsv_word_level is the level of the rows in the tesseract TSV that are words rather than blocks or lines
+	c_tsv_word_level = 5
+)
+
+// OCRWord is a word that tesseract found on the page, with its bounding box in each size of the page images so that
+// the viewer can highlight search hits on any of them.
+type OCRWord struct {
+	Text       string             `json:"text"`
+	Confidence float64            `json:"confidence"`
+	Block      int                `json:"block"`
+	Paragraph  int                `json:"paragraph"`
+	Line       int                `json:"line"`
+	Boxes      map[string]WordBox `json:"boxes"`
+}
+
+// WordBox is the bounding box of an OCRWord in pixels from the top left corner of an image.
+type WordBox struct {
+	Left   int `json:"left"`
+	Top    int `json:"top"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// parseTesseractTsv reads the words of the `tesseract ... tsv` output with their bounding boxes in the image that
+// tesseract read, which is stored as the c_image_original box. Rows that are not words or that have no text are skipped.
+func parseTesseractTsv(r io.Reader) ([]OCRWord, error) {
+	var words []OCRWord
+	scanner := bufio.NewScanner(r)
+	header := true
+	for scanner.Scan() {
+		if header {
+			// level page_num block_num par_num line_num word_num left top width height conf text
+			header = false
+			continue
+		}
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 12 {
+			continue
+		}
+		text := strings.TrimSpace(strings.Join(fields[11:], "\t"))
+		if len(text) == 0 {
+			continue
+		}
+		var numbers [10]int
+		for i := range numbers {
+			number, err := strconv.Atoi(fields[i])
+			if err != nil {
+				return nil, fmt.Errorf("the column %d of the tesseract TSV row %q is not a number", i+1, scanner.Text())
+			}
+			numbers[i] = number
+		}
+		if numbers[0] != c_tsv_word_level {
+			continue
+		}
+		confidence, err := strconv.ParseFloat(fields[10], 64)
+		if err != nil {
+			return nil, fmt.Errorf("the confidence of the tesseract TSV row %q is not a number", scanner.Text())
+		}
+		words = append(words, OCRWord{
+			Text:       text,
+			Confidence: confidence,
+			Block:      numbers[2],
+			Paragraph:  numbers[3],
+			Line:       numbers[4],
+			Boxes: map[string]WordBox{
+				c_image_original: {Left: numbers[6], Top: numbers[7], Width: numbers[8], Height: numbers[9]},
+			},
+		})
+	}
+	return words, scanner.Err()
+}
+
+// imageSizes returns the dimensions of each of the images that exist, keyed by their size name.
+func imageSizes(images Images) map[string]image.Point {
+	sizes := make(map[string]image.Point)
+	for name, path := range map[string]string{
+		c_image_original: images.Original,
+		c_image_large:    images.Large,
+		c_image_medium:   images.Medium,
+		c_image_small:    images.Small,
+		c_image_social:   images.Social,
+	} {
+		if len(path) == 0 {
+			continue
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		config, _, err := image.DecodeConfig(file)
+		file.Close()
+		if err != nil {
+			log.Printf("failed to read the dimensions of %v due to error %v", path, err)
+			continue
+		}
+		sizes[name] = image.Point{X: config.Width, Y: config.Height}
+	}
+	return sizes
+}
+
+// scaleWordBoxes adds a box for every size of the page images to the words, scaled from their c_image_original box.
+func scaleWordBoxes(words []OCRWord, sizes map[string]image.Point) {
+	original, found := sizes[c_image_original]
+	if !found || original.X == 0 || original.Y == 0 {
+		return
+	}
+	for _, word := range words {
+		box := word.Boxes[c_image_original]
+		for name, size := range sizes {
+			if name == c_image_original {
+				continue
+			}
+			x := float64(size.X) / float64(original.X)
+			y := float64(size.Y) / float64(original.Y)
+			word.Boxes[name] = WordBox{
+				Left:   int(math.Round(float64(box.Left) * x)),
+				Top:    int(math.Round(float64(box.Top) * y)),
+				Width:  int(math.Round(float64(box.Width) * x)),
+				Height: int(math.Round(float64(box.Height) * y)),
+			}
+		}
+	}
+}
+
+// loadOcrWords parses the tesseract TSV of the page into its OCRWords, scaled to the light images of the page. The
+// PNG images are used while they exist and the JPEG images after convertPngToJpg replaced them.
+func loadOcrWords(pp PendingPage) PendingPage {
+	file, err := os.Open(pp.OCRTSVPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("failed to open the tesseract TSV %v of page %v due to error %v", pp.OCRTSVPath, pp.Identifier, err)
+		}
+		return pp
+	}
+	defer file.Close()
+	words, err := parseTesseractTsv(file)
+	if err != nil {
+		log.Printf("failed to parse the tesseract TSV %v of page %v due to error %v", pp.OCRTSVPath, pp.Identifier, err)
+		return pp
+	}
+	sizes := imageSizes(pp.JPEG.Light)
+	for name, size := range imageSizes(pp.PNG.Light) {
+		sizes[name] = size
+	}
+	scaleWordBoxes(words, sizes)
+	pp.OCRWords = words
+	return pp
+}
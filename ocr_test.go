@@ -0,0 +1,113 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`image`
+	`image/png`
+	`os`
+	`path/filepath`
+	`reflect`
+	`strings`
+	`testing`
+)
+
+const testTesseractTsv = "level\tpage_num\tblock_num\tpar_num\tline_num\tword_num\tleft\ttop\twidth\theight\tconf\ttext\n" +
+	"1\t1\t0\t0\t0\t0\t0\t0\t1000\t2000\t-1\t\n" +
+	"2\t1\t1\t0\t0\t0\t100\t200\t400\t60\t-1\t\n" +
+	"4\t1\t1\t1\t1\t0\t100\t200\t400\t60\t-1\t\n" +
+	"5\t1\t1\t1\t1\t1\t100\t200\t180\t60\t96.5\tTOP\n" +
+	"5\t1\t1\t1\t1\t2\t300\t200\t200\t60\t91.25\tSECRET\n" +
+	"5\t1\t1\t1\t1\t3\t520\t200\t10\t60\t95\t \n"
+
+func Test_parseTesseractTsv(t *testing.T) {
+	tests := []struct {
+		name    string
+		tsv     string
+		want    []OCRWord
+		wantErr bool
+	}{
+		{
+			name: "words with boxes",
+			tsv:  testTesseractTsv,
+			want: []OCRWord{
+				{Text: "TOP", Confidence: 96.5, Block: 1, Paragraph: 1, Line: 1, Boxes: map[string]WordBox{c_image_original: {Left: 100, Top: 200, Width: 180, Height: 60}}},
+				{Text: "SECRET", Confidence: 91.25, Block: 1, Paragraph: 1, Line: 1, Boxes: map[string]WordBox{c_image_original: {Left: 300, Top: 200, Width: 200, Height: 60}}},
+			},
+		},
+		{
+			name:    "malformed row",
+			tsv:     "level\tpage_num\tblock_num\tpar_num\tline_num\tword_num\tleft\ttop\twidth\theight\tconf\ttext\n5\t1\t1\t1\t1\t1\tx\t200\t180\t60\t96\tTOP\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTesseractTsv(strings.NewReader(tt.tsv))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTesseractTsv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTesseractTsv() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_loadOcrWords(t *testing.T) {
+	dir := t.TempDir()
+	writePng := func(name string, width int, height int) string {
+		path := filepath.Join(dir, name)
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if err := png.Encode(file, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	pp := PendingPage{
+		Identifier: "page",
+		OCRTSVPath: filepath.Join(dir, "ocr.000001.tsv"),
+		PNG: PNG{Light: Images{
+			Original: writePng("original.png", 1000, 2000),
+			Large:    writePng("large.png", 500, 1000),
+			Small:    writePng("small.png", 250, 500),
+			// the medium image was already converted to a JPEG, so it is skipped
+			Medium: filepath.Join(dir, "medium.png"),
+		}},
+	}
+	if err := os.WriteFile(pp.OCRTSVPath, []byte(testTesseractTsv), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pp = loadOcrWords(pp)
+	if len(pp.OCRWords) != 2 {
+		t.Fatalf("loadOcrWords() = %d words, want 2", len(pp.OCRWords))
+	}
+	want := map[string]WordBox{
+		c_image_original: {Left: 300, Top: 200, Width: 200, Height: 60},
+		c_image_large:    {Left: 150, Top: 100, Width: 100, Height: 30},
+		c_image_small:    {Left: 75, Top: 50, Width: 50, Height: 15},
+	}
+	if got := pp.OCRWords[1].Boxes; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the boxes of %v to be %v, but got %v", pp.OCRWords[1].Text, want, got)
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`fmt`
+	`os`
+	`path/filepath`
+
+	`github.com/pdfcpu/pdfcpu/pkg/api`
+	`github.com/pdfcpu/pdfcpu/pkg/pdfcpu`
+	`github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model`
+)
+
+// PDFValidationError is returned when pdfcpu cannot read or validate a PDF.
+type PDFValidationError struct {
+	Path string
+	Err  error
+}
+
+func (e *PDFValidationError) Error() string {
+	return fmt.Sprintf("failed to validate the pdf %v: %v", e.Path, e.Err)
+}
+
+func (e *PDFValidationError) Unwrap() error {
+	return e.Err
+}
+
+// PDFInfo is what validatePdfFile learned about a valid PDF.
+type PDFInfo struct {
+	PageCount int    `json:"page_count"`
+	Version   string `json:"version"`
+}
+
+// disablePdfcpuConfigDir stops pdfcpu from creating its configuration directory in the home directory of the user.
+func disablePdfcpuConfigDir() {
+	once_pdfcpu_config.Do(api.DisableConfigDir)
+}
+
+// pdfcpuConfiguration returns the relaxed validation that `pdfcpu validate` uses by default.
+func pdfcpuConfiguration() *model.Configuration {
+	disablePdfcpuConfigDir()
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+	return conf
+}
+
+// readPdfContext reads and validates the PDF at path with pdfcpu while holding sem_pdfcpu.
+func readPdfContext(ctx context.Context, path string) (*model.Context, error) {
+	sem_pdfcpu.Acquire()
+	defer sem_pdfcpu.Release()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, &PDFValidationError{Path: path, Err: err}
+	}
+	defer file.Close()
+	pdfCtx, err := api.ReadContext(file, pdfcpuConfiguration())
+	if err != nil {
+		return nil, &PDFValidationError{Path: path, Err: err}
+	}
+	err = api.ValidateContext(pdfCtx)
+	if err != nil {
+		return nil, &PDFValidationError{Path: path, Err: err}
+	}
+	err = pdfCtx.EnsurePageCount()
+	if err != nil {
+		return nil, &PDFValidationError{Path: path, Err: err}
+	}
+	return pdfCtx, nil
+}
+
+// validatePdfFile returns the PDFInfo of the PDF at path or a *PDFValidationError when pdfcpu rejects it.
+func validatePdfFile(ctx context.Context, path string) (PDFInfo, error) {
+	pdfCtx, err := readPdfContext(ctx, path)
+	if err != nil {
+		return PDFInfo{}, err
+	}
+	return PDFInfo{PageCount: pdfCtx.PageCount, Version: pdfCtx.VersionString()}, nil
+}
+
+// optimizePdfFile is the in-process `pdfcpu optimize input output`.
+func optimizePdfFile(ctx context.Context, input string, output string) error {
+	pdfCtx, err := readPdfContext(ctx, input)
+	if err != nil {
+		return err
+	}
+	sem_pdfcpu.Acquire()
+	defer sem_pdfcpu.Release()
+	err = api.OptimizeContext(pdfCtx)
+	if err != nil {
+		return err
+	}
+	return api.WriteContextFile(pdfCtx, output)
+}
+
+// pagePdfPath returns where extractPdfPages writes the single page PDF of page pgNo.
+func pagePdfPath(pagesDir string, pgNo int) string {
+	return filepath.Join(pagesDir, fmt.Sprintf("page.%06d.pdf", pgNo))
+}
+
+// extractPdfPages writes every page of the PDF at path into its own pagePdfPath inside the pagesDir and returns the
+// number of pages. Pages that a previous run already extracted are kept.
+func extractPdfPages(ctx context.Context, path string, pagesDir string) (int, error) {
+	pdfCtx, err := readPdfContext(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	sem_pdfcpu.Acquire()
+	defer sem_pdfcpu.Release()
+	for pgNo := 1; pgNo <= pdfCtx.PageCount; pgNo++ {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		pagePath := pagePdfPath(pagesDir, pgNo)
+		if info, statErr := os.Stat(pagePath); statErr == nil && info.Size() > 0 {
+			continue
+		}
+		pageCtx, err := pdfcpu.ExtractPage(pdfCtx, pgNo)
+		if err != nil {
+			return 0, fmt.Errorf("failed to extract page %d of %v due to error %v", pgNo, path, err)
+		}
+		temp := pagePath + c_normalize_pdf_ext
+		err = api.WriteContextFile(pageCtx, temp)
+		if err == nil {
+			err = os.Rename(temp, pagePath)
+		}
+		if err != nil {
+			os.Remove(temp)
+			return 0, fmt.Errorf("failed to write page %d of %v to %v due to error %v", pgNo, path, pagePath, err)
+		}
+	}
+	return pdfCtx.PageCount, nil
+}
@@ -0,0 +1,128 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`errors`
+	`fmt`
+	`os`
+	`path/filepath`
+	`strings`
+	`testing`
+)
+
+// writeTestPdf writes a valid PDF with the number of blank pages to path.
+func writeTestPdf(t *testing.T, path string, pages int) {
+	t.Helper()
+	var kids []string
+	objects := []string{"<< /Type /Catalog /Pages 2 0 R >>", ""}
+	for i := 0; i < pages; i++ {
+		kids = append(kids, fmt.Sprintf("%d 0 R", i+3))
+		objects = append(objects, "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << >> >>")
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%v] /Count %d >>", strings.Join(kids, " "), pages)
+
+	var pdf strings.Builder
+	// pdfcpu looks for the xref in the last kilobyte or so of the file, which small files need padding for
+	pdf.WriteString("%PDF-1.4\n%" + strings.Repeat(" ", 1024) + "\n")
+	var offsets []int
+	for i, object := range objects {
+		offsets = append(offsets, pdf.Len())
+		fmt.Fprintf(&pdf, "%d 0 obj\n%v\nendobj\n", i+1, object)
+	}
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	if err := os.WriteFile(path, []byte(pdf.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_validatePdfFile(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.pdf")
+	writeTestPdf(t, valid, 3)
+	invalid := filepath.Join(dir, "invalid.pdf")
+	if err := os.WriteFile(invalid, []byte("%PDF-1.4 truncated"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		pageCount int
+		wantErr   bool
+	}{
+		{name: "valid", path: valid, pageCount: 3},
+		{name: "invalid", path: invalid, wantErr: true},
+		{name: "missing", path: filepath.Join(dir, "missing.pdf"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := validatePdfFile(context.Background(), tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validatePdfFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var validationErr *PDFValidationError
+			if tt.wantErr && (!errors.As(err, &validationErr) || validationErr.Path != tt.path) {
+				t.Errorf("Expected a *PDFValidationError for %v, but got %#v", tt.path, err)
+			}
+			if info.PageCount != tt.pageCount {
+				t.Errorf("validatePdfFile() PageCount = %d, want %d", info.PageCount, tt.pageCount)
+			}
+		})
+	}
+}
+
+func Test_extractPdfPages(t *testing.T) {
+	dir := t.TempDir()
+	pdfPath := filepath.Join(dir, "a.pdf")
+	writeTestPdf(t, pdfPath, 3)
+	pagesDir := filepath.Join(dir, "pages")
+	if err := os.MkdirAll(pagesDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"extracts every page", "extracts the missing page"} {
+		t.Run(name, func(t *testing.T) {
+			pageCount, err := extractPdfPages(context.Background(), pdfPath, pagesDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pageCount != 3 {
+				t.Fatalf("extractPdfPages() = %d pages, want 3", pageCount)
+			}
+			for pgNo := 1; pgNo <= pageCount; pgNo++ {
+				info, err := validatePdfFile(context.Background(), pagePdfPath(pagesDir, pgNo))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.PageCount != 1 {
+					t.Errorf("Expected page %d to be a single page PDF, but it has %d pages", pgNo, info.PageCount)
+				}
+			}
+		})
+		if err := os.Remove(pagePdfPath(pagesDir, 2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -18,14 +18,13 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
 )
 
@@ -37,58 +36,24 @@ func validatePdf(ctx context.Context, record ResultData) (ResultData, error) {
 	defer wg_active_tasks.Done()
 	log.Printf("started validatePdf(%v) = %v", record.Identifier, record.PDFPath)
 
-	_, rjsonErr := os.Stat(record.RecordPath)
-	if os.IsNotExist(rjsonErr) {
-		/*
-			pdfcpu validate REPLACE_WITH_FILE_PATH | grep 'validation ok'
-		*/
-		cmd0_validate_pdf := exec.Command(m_required_binaries["pdfcpu"], "validate", record.PDFPath)
-		var cmd0_validate_pdf_stdout bytes.Buffer
-		var cmd0_validate_pdf_stderr bytes.Buffer
-		cmd0_validate_pdf.Stdout = &cmd0_validate_pdf_stdout
-		cmd0_validate_pdf.Stderr = &cmd0_validate_pdf_stderr
-		sem_pdfcpu.Acquire()
-		cmd0_validate_pdf_err := cmd0_validate_pdf.Run()
-		sem_pdfcpu.Release()
-
-		if cmd0_validate_pdf_err != nil {
-			return record, fmt.Errorf("Failed to execute `pdfcpu validate %v` due to error: %s\n", record.PDFPath, cmd0_validate_pdf_err)
-		}
-
-		if !strings.Contains(cmd0_validate_pdf_stdout.String(), "validation ok") {
-			return record, fmt.Errorf("failed to validate the pdf %v\n\tSTDOUT = %v", record.PDFPath, cmd0_validate_pdf_stdout.String())
-		}
-		/*
-			gs -q -sDEVICE=pdfwrite -dCompatibilityLevel=1.7 -o REPLACE_WITH_FILE_PATH REPLACE_WITH_FILE_PATH
-		*/
-		cmd1_convert_pdf := exec.Command(m_required_binaries["gs"], "-q -sDEVICE=pdfwrite -dCompatibilityLevel=1.7 -o", record.PDFPath, record.PDFPath)
-		var cmd1_convert_pdf_stdout bytes.Buffer
-		var cmd1_convert_pdf_stderr bytes.Buffer
-		cmd1_convert_pdf.Stdout = &cmd1_convert_pdf_stdout
-		cmd1_convert_pdf.Stderr = &cmd1_convert_pdf_stderr
-		sem_gs.Acquire()
-		cmd1_convert_pdf_err := cmd1_convert_pdf.Run()
-		sem_gs.Release()
-		if cmd1_convert_pdf_err != nil {
-			return record, fmt.Errorf("Failed to execute command `gs -q -sDEVICE=pdfwrite -dCompatibilityLevel=1.7 -o %v %v` due to error: %s\n", record.PDFPath, record.PDFPath, cmd1_convert_pdf_err)
-		}
+	if journalRecordHasStage(record.Identifier, c_journal_record_validated) {
+		log.Printf("skipping validatePdf(%v) because a previous run already validated %v", record.Identifier, record.PDFPath)
+		return record, nil
+	}
 
-		/*
-			pdfcpu optimize REPLACE_WITH_FILE_PATH
-		*/
-		cmd2_optimize_pdf := exec.Command(m_required_binaries["pdfcpu"], "optimize", record.PDFPath)
-		var cmd2_optimize_pdf_stdout bytes.Buffer
-		var cmd2_optimize_pdf_stderr bytes.Buffer
-		cmd2_optimize_pdf.Stdout = &cmd2_optimize_pdf_stdout
-		cmd2_optimize_pdf.Stderr = &cmd2_optimize_pdf_stderr
-		sem_pdfcpu.Acquire()
-		cmd2_optimize_pdf_err := cmd2_optimize_pdf.Run()
-		sem_pdfcpu.Release()
-		if cmd2_optimize_pdf_err != nil {
-			return record, fmt.Errorf("Failed to execute command `pdfcpu optimize %v` due to error: %s\n", record.PDFPath, cmd2_optimize_pdf_err)
-		}
+	normalization, err := normalizePdf(ctx, record)
+	record.Normalization = &normalization
+	writeErr := writeRecordJson(record)
+	if writeErr != nil {
+		log.Printf("failed to record the normalization of %v in %v due to error %v", record.PDFPath, record.RecordPath, writeErr)
+	}
+	if err != nil {
+		return record, err
 	}
+	sm_documents.Store(record.Identifier, record)
+	log.Printf("normalized %v (%d pages) with the %v repair", record.PDFPath, normalization.PageCount, normalization.Repair)
 
+	journalRecordStage(record, c_journal_record_validated)
 	return record, nil
 }
 
@@ -104,159 +69,182 @@ func extractPlainTextFromPdf(ctx context.Context, record ResultData) {
 		}
 	}()
 	log.Printf("started extractPlainTextFromPdf(%v) = %v", record.Identifier, record.PDFPath)
+	if journalRecordHasStage(record.Identifier, c_journal_record_text_extracted) {
+		return
+	}
 	if ok, err := fileHasData(record.ExtractedTextPath); !ok || err != nil {
 		/*
 			pdftotext REPLACE_WITH_FILE_PATH REPLACE_WITH_TEXT_OUTPUT_FILE_PATH
 		*/
-		cmd4_extract_text_pdf := exec.Command(m_required_binaries["pdftotext"], record.PDFPath, record.ExtractedTextPath)
-		var cmd4_extract_text_pdf_stdout bytes.Buffer
-		var cmd4_extract_text_pdf_stderr bytes.Buffer
-		cmd4_extract_text_pdf.Stdout = &cmd4_extract_text_pdf_stdout
-		cmd4_extract_text_pdf.Stderr = &cmd4_extract_text_pdf_stderr
-		sem_pdftotext.Acquire()
-		cmd4_extract_text_pdf_err := cmd4_extract_text_pdf.Run()
-		sem_pdftotext.Release()
+		cmd4_extract_text_pdf := ToolCommand{Name: "pdftotext", Args: []string{record.PDFPath, record.ExtractedTextPath}, Semaphore: sem_pdftotext}
+		_, cmd4_extract_text_pdf_err := runTool(ctx, cmd4_extract_text_pdf)
 		if cmd4_extract_text_pdf_err != nil {
-			log.Printf("Failed to execute command `pdftotext %v %v` due to error: %s\n", record.PDFPath, record.ExtractedTextPath, cmd4_extract_text_pdf_err)
+			log.Printf("Failed to execute command `%v` due to error: %s\n", cmd4_extract_text_pdf, cmd4_extract_text_pdf_err)
 			return
 		}
 	}
+	journalRecordStage(record, c_journal_record_text_extracted)
 }
 
 func extractPagesFromPdf(ctx context.Context, record ResultData) {
 	defer wg_active_tasks.Done()
+	dispatchedPages := 0
+	defer func() {
+		aggregateExpectPages(ctx, record.Identifier, dispatchedPages)
+	}()
 	log.Printf("started extractPagesFromPdf(%v) = %v", record.Identifier, record.PDFPath)
-	/*
-		pdfcpu extract -mode page REPLACE_WITH_FILE_PATH REPLACE_WITH_OUTPUT_DIRECTORY
-	*/
 	pagesDir := filepath.Join(record.DataDir, "pages")
 	sm_page_directories.Store(record.Identifier, pagesDir)
-	_, pagesDirExistsErr := os.Stat(pagesDir)
-	performPagesExtract := false
-	if os.IsNotExist(pagesDirExistsErr) {
-		performPagesExtract = true
-	} else {
-		ok, err := DirHasPDFs(pagesDir)
-		if err == nil && ok {
-			performPagesExtract = true
-		}
+	pagesDirErr := os.MkdirAll(pagesDir, 0755)
+	if pagesDirErr != nil {
+		log.Printf("failed to create directory %v due to error %v", pagesDir, pagesDirErr)
+		return
 	}
-	if performPagesExtract {
-		pagesDirErr := os.MkdirAll(pagesDir, 0755)
-		if pagesDirErr != nil {
-			log.Printf("failed to create directory %v due to error %v", pagesDir, pagesDirErr)
-			return
-		}
-		cmd5_extract_pages_in_pdf := exec.Command(m_required_binaries["pdfcpu"], "extract", "-mode", "page", record.PDFPath, pagesDir)
-		var cmd5_extract_pages_in_pdf_stdout bytes.Buffer
-		var cmd5_extract_pages_in_pdf_stderr bytes.Buffer
-		cmd5_extract_pages_in_pdf.Stdout = &cmd5_extract_pages_in_pdf_stdout
-		cmd5_extract_pages_in_pdf.Stderr = &cmd5_extract_pages_in_pdf_stderr
-		sem_pdfcpu.Acquire()
-		cmd5_extract_pages_in_pdf_err := cmd5_extract_pages_in_pdf.Run()
-		sem_pdfcpu.Release()
-		if cmd5_extract_pages_in_pdf_err != nil {
-			log.Printf("Failed to execute command `pdfcpu extract -mode page %v %v` due to error: %s\n", record.PDFPath, pagesDir, cmd5_extract_pages_in_pdf_err)
-			return
-		}
-	} else {
-		log.Printf("not performing `pdfcpu extrace -mode page %v %v` because the directory %v already has PDFs inside it", record.PDFPath, pagesDir, pagesDir)
+	// pages that a previous run extracted are kept, so this only writes the pages that are missing
+	pageCount, extractErr := extractPdfPages(ctx, record.PDFPath, pagesDir)
+	if extractErr != nil {
+		log.Printf("failed to extract the pages of %v into %v due to error %v", record.PDFPath, pagesDir, extractErr)
+		return
 	}
-
-	pagesDirWalkErr := filepath.Walk(pagesDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			log.Printf("Error accessing a path %q: %v\n", path, err)
-			return err
-		}
-
-		if !info.IsDir() && strings.HasSuffix(info.Name(), ".pdf") {
-			nameParts := strings.Split(info.Name(), "_page_")
-			if len(nameParts) < 2 {
-				return fmt.Errorf("incorrect filename provided as %v", info.Name())
-			}
-			pgNoStr := strings.ReplaceAll(nameParts[1], ".pdf", "")
-			pgNo, pgNoErr := strconv.Atoi(pgNoStr)
-			if pgNoErr != nil {
-				return fmt.Errorf("failed to extract the pgNo from the PDF filename %v", info.Name())
-			}
-			identifier := NewIdentifier(9)
-			pp := PendingPage{
-				Identifier:       identifier,
-				RecordIdentifier: record.Identifier,
-				PageNumber:       pgNo,
-				PagesDir:         pagesDir,
-				PDFPath:          path,
-				OCRTextPath:      filepath.Join(pagesDir, fmt.Sprintf("ocr.%06d.txt", pgNo)),
-				ManifestPath:     filepath.Join(pagesDir, fmt.Sprintf("page.%06d.json", pgNo)),
-				PNG: PNG{
-					Light: Images{
-						Original: filepath.Join(pagesDir, fmt.Sprintf("page.light.%06d.original.png", pgNo)),
-						Large:    filepath.Join(pagesDir, fmt.Sprintf("page.light.%06d.large.png", pgNo)),
-						Medium:   filepath.Join(pagesDir, fmt.Sprintf("page.light.%06d.medium.png", pgNo)),
-						Small:    filepath.Join(pagesDir, fmt.Sprintf("page.light.%06d.small.png", pgNo)),
-						Social:   filepath.Join(pagesDir, fmt.Sprintf("page.light.%06d.social.png", pgNo)),
-					},
-					Dark: Images{
-						Original: filepath.Join(pagesDir, fmt.Sprintf("page.dark.%06d.original.png", pgNo)),
-						Large:    filepath.Join(pagesDir, fmt.Sprintf("page.dark.%06d.large.png", pgNo)),
-						Medium:   filepath.Join(pagesDir, fmt.Sprintf("page.dark.%06d.medium.png", pgNo)),
-						Small:    filepath.Join(pagesDir, fmt.Sprintf("page.dark.%06d.small.png", pgNo)),
-						Social:   filepath.Join(pagesDir, fmt.Sprintf("page.dark.%06d.social.png", pgNo)),
-					},
+	if !journalRecordHasStage(record.Identifier, c_journal_record_pages_extracted) {
+		journalRecordStage(record, c_journal_record_pages_extracted)
+	}
+	record = reconcileRecord(ctx, record, pageCount)
+
+	for pgNo := 1; pgNo <= pageCount; pgNo++ {
+		path := pagePdfPath(pagesDir, pgNo)
+		identifier := journalPageIdentifier(record.Identifier, pgNo)
+		pp := PendingPage{
+			Identifier:       identifier,
+			RecordIdentifier: record.Identifier,
+			PageNumber:       pgNo,
+			PagesDir:         pagesDir,
+			PDFPath:          path,
+			OCRTextPath:      filepath.Join(pagesDir, fmt.Sprintf("ocr.%06d.txt", pgNo)),
+			OCRTSVPath:       filepath.Join(pagesDir, fmt.Sprintf("ocr.%06d.tsv", pgNo)),
+			TextLayerPath:    filepath.Join(pagesDir, fmt.Sprintf("text.%06d.txt", pgNo)),
+			ManifestPath:     filepath.Join(pagesDir, fmt.Sprintf("page.%06d.json", pgNo)),
+			PNG: PNG{
+				Light: Images{
+					Original: filepath.Join(pagesDir, fmt.Sprintf("page.light.%06d.original.png", pgNo)),
+					Large:    filepath.Join(pagesDir, fmt.Sprintf("page.light.%06d.large.png", pgNo)),
+					Medium:   filepath.Join(pagesDir, fmt.Sprintf("page.light.%06d.medium.png", pgNo)),
+					Small:    filepath.Join(pagesDir, fmt.Sprintf("page.light.%06d.small.png", pgNo)),
+					Social:   filepath.Join(pagesDir, fmt.Sprintf("page.light.%06d.social.png", pgNo)),
+				},
+				Dark: Images{
+					Original: filepath.Join(pagesDir, fmt.Sprintf("page.dark.%06d.original.png", pgNo)),
+					Large:    filepath.Join(pagesDir, fmt.Sprintf("page.dark.%06d.large.png", pgNo)),
+					Medium:   filepath.Join(pagesDir, fmt.Sprintf("page.dark.%06d.medium.png", pgNo)),
+					Small:    filepath.Join(pagesDir, fmt.Sprintf("page.dark.%06d.small.png", pgNo)),
+					Social:   filepath.Join(pagesDir, fmt.Sprintf("page.dark.%06d.social.png", pgNo)),
 				},
-				JPEG: JPEG{
-					Light: Images{
-						Original: filepath.Join(pagesDir, fmt.Sprintf("page.light.%06d.original.jpg", pgNo)),
-						Large:    filepath.Join(pagesDir, fmt.Sprintf("page.light.%06d.large.jpg", pgNo)),
-						Medium:   filepath.Join(pagesDir, fmt.Sprintf("page.light.%06d.medium.jpg", pgNo)),
-						Small:    filepath.Join(pagesDir, fmt.Sprintf("page.light.%06d.small.jpg", pgNo)),
-						Social:   filepath.Join(pagesDir, fmt.Sprintf("page.light.%06d.social.jpg", pgNo)),
-					},
-					Dark: Images{
-						Original: filepath.Join(pagesDir, fmt.Sprintf("page.dark.%06d.original.jpg", pgNo)),
-						Large:    filepath.Join(pagesDir, fmt.Sprintf("page.dark.%06d.large.jpg", pgNo)),
-						Medium:   filepath.Join(pagesDir, fmt.Sprintf("page.dark.%06d.medium.jpg", pgNo)),
-						Small:    filepath.Join(pagesDir, fmt.Sprintf("page.dark.%06d.small.jpg", pgNo)),
-						Social:   filepath.Join(pagesDir, fmt.Sprintf("page.dark.%06d.social.jpg", pgNo)),
-					},
+			},
+			JPEG: JPEG{
+				Light: Images{
+					Original: filepath.Join(pagesDir, fmt.Sprintf("page.light.%06d.original.jpg", pgNo)),
+					Large:    filepath.Join(pagesDir, fmt.Sprintf("page.light.%06d.large.jpg", pgNo)),
+					Medium:   filepath.Join(pagesDir, fmt.Sprintf("page.light.%06d.medium.jpg", pgNo)),
+					Small:    filepath.Join(pagesDir, fmt.Sprintf("page.light.%06d.small.jpg", pgNo)),
+					Social:   filepath.Join(pagesDir, fmt.Sprintf("page.light.%06d.social.jpg", pgNo)),
 				},
+				Dark: Images{
+					Original: filepath.Join(pagesDir, fmt.Sprintf("page.dark.%06d.original.jpg", pgNo)),
+					Large:    filepath.Join(pagesDir, fmt.Sprintf("page.dark.%06d.large.jpg", pgNo)),
+					Medium:   filepath.Join(pagesDir, fmt.Sprintf("page.dark.%06d.medium.jpg", pgNo)),
+					Small:    filepath.Join(pagesDir, fmt.Sprintf("page.dark.%06d.small.jpg", pgNo)),
+					Social:   filepath.Join(pagesDir, fmt.Sprintf("page.dark.%06d.social.jpg", pgNo)),
+				},
+			},
+		}
+		resumeAt := journalPageResumeAt(record.Identifier, pgNo)
+		if resumeAt > 0 {
+			manifest, manifestErr := os.ReadFile(pp.ManifestPath)
+			if manifestErr == nil {
+				manifestErr = json.Unmarshal(manifest, &pp)
 			}
-			sm_pages.Store(pp.Identifier, pp)
+			if manifestErr != nil {
+				log.Printf("restarting page %d (ID %v) from the beginning because its manifest %v cannot be loaded due to error %v", pgNo, identifier, pp.ManifestPath, manifestErr)
+				resumeAt = 0
+			}
+		}
+		sm_pages.Store(pp.Identifier, pp)
+		if resumeAt == 0 {
 			err := WritePendingPageToJson(pp)
 			if err != nil {
-				return err
+				log.Printf("failed to write the manifest %v of page %d (ID %v) due to error %v", pp.ManifestPath, pgNo, identifier, err)
+				return
 			}
-			log.Printf("sending page %d (ID %v) from record %v URL %v into the ch_GeneratingPng", pgNo, identifier, record.Identifier, record.URL)
-			wg_active_tasks.Add(10)
-			// 01 - convertPageToPng - done = in the event of a failure, this func will call wg_active_tasks.Done() 9 times
-			// 02 - generateLightThumbnails - done
-			// 03 - generateDarkThumbnails - done
-			// 04 - performOcrOnPdf - done
-			// 05 - convertPngToJpg - done
-			// 06 - analyze_StartOnFullText - done
-			// 07 - analyzeCryptonyms - done
-			// 08 - analyzeLocations - done
-			// 09 - analyzeGematria - done
-			// 10 - analyzeWordIndexer - done
-
-			if ch_GeneratePng.CanWrite() {
-				err := ch_GeneratePng.Write(pp)
-				if err != nil {
-					log.Printf("cannot send pp into ch_GeneratePng channel due to error %v", err)
-					return err
+		}
+		if resumeAt >= len(sl_journal_page_stages) {
+			log.Printf("page %d (ID %v) from record %v was completed by a previous run", pgNo, identifier, record.Identifier)
+			wg_active_tasks.Add(1)
+			aggregatePendingPage(ctx, pp)
+			dispatchedPages++
+			continue
+		}
+		if resumeAt > 0 {
+			log.Printf("resuming page %d (ID %v) from record %v at the %v stage", pgNo, identifier, record.Identifier, sl_journal_page_stages[resumeAt])
+		}
+		log.Printf("sending page %d (ID %v) from record %v URL %v into the pipeline at stage %v", pgNo, identifier, record.Identifier, record.URL, sl_journal_page_stages[resumeAt])
+		remainingStages := len(sl_journal_page_stages) - resumeAt
+		wg_active_tasks.Add(remainingStages)
+		// the stages that were journaled as completed by a previous run are skipped, otherwise:
+		// 01 - convertPageToPng - done = in the event of a failure, this func will call wg_active_tasks.Done() 9 times and aggregateFailedPage
+		// 02 - generateLightThumbnails - done
+		// 03 - generateDarkThumbnails - done
+		// 04 - performOcrOnPdf - done
+		// 05 - convertPngToJpg - done
+		// 06 - analyze_StartOnFullText - done
+		// 07 - analyzeCryptonyms - done
+		// 08 - analyzeLocations - done
+		// 09 - analyzeGematria - done
+		// 10 - analyzeWordIndexer - done
+		// 11 - aggregatePendingPage - done
+
+		stageCh := journalPageChannels()[resumeAt]
+		if stageCh.CanWrite() {
+			err := stageCh.Write(pp)
+			if err != nil {
+				log.Printf("cannot send pp into the %v stage channel due to error %v", sl_journal_page_stages[resumeAt], err)
+				for i := 1; i <= remainingStages; i++ {
+					wg_active_tasks.Done()
 				}
+				return
+			}
+			dispatchedPages++
+		} else {
+			for i := 1; i <= remainingStages; i++ {
+				wg_active_tasks.Done()
 			}
 		}
+	}
+}
 
-		return nil
-	})
-
-	if pagesDirWalkErr != nil {
-		log.Printf("Error walking the path ./pages: %v\n", pagesDirWalkErr)
-		return
+// failPage marks the page as failed at the stage in its manifest and hands it to aggregateFailedPage, releasing the
+// wg_active_tasks of the stages after the failed stage so the record is compiled without the page instead of waiting.
+// The stage that calls failPage still releases its own wg_active_tasks and must not send the page any further.
+func failPage(ctx context.Context, pp PendingPage, stage string, reason error) {
+	pp.Failed = true
+	pp.FailedStage = stage
+	pp.FailedReason = reason.Error()
+	sm_pages.Store(pp.Identifier, pp)
+	err := WritePendingPageToJson(pp)
+	if err != nil {
+		log.Printf("failed to mark page %v as failed in its manifest %v due to error %v", pp.Identifier, pp.ManifestPath, err)
 	}
 
-	return
+	remaining := 0
+	for i, s := range sl_journal_page_stages {
+		if s == stage {
+			// the stages after this one, except for the aggregate which aggregateFailedPage releases
+			remaining = len(sl_journal_page_stages) - i - 2
+		}
+	}
+	for i := 0; i < remaining; i++ {
+		wg_active_tasks.Done()
+	}
+	aggregateFailedPage(ctx, pp, reason)
 }
 
 func convertPageToPng(ctx context.Context, pp PendingPage) {
@@ -268,34 +256,25 @@ func convertPageToPng(ctx context.Context, pp PendingPage) {
 	_, loErr := os.Stat(pp.PNG.Light.Original)
 	if os.IsNotExist(loErr) {
 		originalFilename := strings.ReplaceAll(pp.PNG.Light.Original, `.png`, ``)
-		cmd := exec.Command(m_required_binaries["pdftoppm"],
+		cmd := ToolCommand{Name: "pdftoppm", Args: []string{
 			`-r`, `369`, `-png`, `-freetype`, `yes`, `-aa`, `yes`, `-aaVector`, `yes`, `-thinlinemode`, `solid`,
-			pp.PDFPath, originalFilename)
-		var cmd_stdout bytes.Buffer
-		var cmd_stderr bytes.Buffer
-		cmd.Stdout = &cmd_stdout
-		cmd.Stderr = &cmd_stderr
-		sem_pdftoppm.Acquire()
-		cmd_err := cmd.Run()
-		sem_pdftoppm.Release()
+			pp.PDFPath, originalFilename}, Semaphore: sem_pdftoppm}
+		_, cmd_err := runTool(ctx, cmd)
 		if cmd_err != nil {
 			log.Printf("failed to convert page %v to png %v due to error: %s\n", filepath.Base(pp.PDFPath), pp.PNG.Light.Original, cmd_err)
-			for i := 1; i <= 9; i++ {
-				wg_active_tasks.Done()
-			}
+			failPage(ctx, pp, c_journal_page_png, cmd_err)
 			return
 		}
 
 		pngRenameErr := os.Rename(fmt.Sprintf("%v-1.png", originalFilename), fmt.Sprintf("%v.png", originalFilename))
 		if pngRenameErr != nil {
 			log.Printf("failed to rename the jpg %v due to error: %v", originalFilename, pngRenameErr)
-			for i := 1; i <= 9; i++ {
-				wg_active_tasks.Done()
-			}
+			failPage(ctx, pp, c_journal_page_png, pngRenameErr)
 			return
 		}
 	}
 
+	journalPageStage(pp, c_journal_page_png)
 	log.Printf("completed convertPageToPng now sending %v (%v.%v) -> ch_GenerateLight ", pp.PDFPath, pp.RecordIdentifier, pp.Identifier)
 	if ch_GenerateLight.CanWrite() {
 		err := ch_GenerateLight.Write(pp)
@@ -357,11 +336,16 @@ func generateLightThumbnails(ctx context.Context, pp PendingPage) {
 		}
 	}
 
+	journalPageStage(pp, c_journal_page_light)
 }
 
 func generateDarkThumbnails(ctx context.Context, pp PendingPage) {
 	defer wg_active_tasks.Done()
+	failed := false
 	defer func() {
+		if failed {
+			return
+		}
 		log.Printf("completed generateDarkThumbnails now sending %v (%v.%v) -> ch_PerformOcr ", pp.PDFPath, pp.RecordIdentifier, pp.Identifier)
 		if ch_PerformOcr.CanWrite() {
 			err := ch_PerformOcr.Write(pp)
@@ -377,30 +361,26 @@ func generateDarkThumbnails(ctx context.Context, pp PendingPage) {
 	_, ppdoErr := os.Stat(pp.PNG.Dark.Original)
 	if os.IsNotExist(ppdoErr) {
 		// convert REPLACE_WITH_OUTPUT_PNG_PAGE_FILENAME -channel rgba -matte -fill 'rgba(250,226,203,1)' -fuzz 45% -opaque 'rgba(76,76,76,1)' -flatten REPLACE_WITH_OUTPUT_PNG_DARK_PAGE_FILENAME
-		cmdA := exec.Command(m_required_binaries["convert"], pp.PNG.Light.Original, "-channel", "rgba", "-matte", "-fill", `rgba(250,226,203,1)`, "-fuzz", "45%", "-opaque", `rgba(76,76,76,1)`, "-flatten", pp.PNG.Dark.Original)
-		var cmdA_stdout bytes.Buffer
-		var cmdA_stderr bytes.Buffer
-		cmdA.Stdout = &cmdA_stdout
-		cmdA.Stderr = &cmdA_stderr
-		sem_convert.Acquire()
-		cmdA_err := cmdA.Run()
-		sem_convert.Release()
+		cmdA := ToolCommand{Name: "convert", Args: []string{pp.PNG.Light.Original, "-channel", "rgba", "-matte", "-fill", `rgba(250,226,203,1)`, "-fuzz", "45%", "-opaque", `rgba(76,76,76,1)`, "-flatten", pp.PNG.Dark.Original}, Semaphore: sem_convert}
+		_, cmdA_err := runTool(ctx, cmdA)
 		if cmdA_err != nil {
 			log.Printf("failed to convert %v into %v due to error: %s\n", pp.PNG.Light.Original, pp.PNG.Dark.Original, cmdA_err)
+			if errors.Is(cmdA_err, errToolTimeout) {
+				failed = true
+				failPage(ctx, pp, c_journal_page_dark, cmdA_err)
+			}
 			return
 		}
 
 		// convert REPLACE_WITH_OUTPUT_PNG_DARK_PAGE_FILENAME -channel rgba -matte -fill 'rgba(40,40,86,1)' -fuzz 12% -opaque white -flatten REPLACE_WITH_OUTPUT_PNG_DARK_PAGE_FILENAME
-		cmdB := exec.Command(m_required_binaries["convert"], pp.PNG.Dark.Original, `-channel`, `rgba`, `-matte`, `-fill`, `rgba(40,40,86,1)`, `-fuzz`, `12%`, `-opaque`, `white`, `-flatten`, pp.PNG.Dark.Original)
-		var cmdB_stdout bytes.Buffer
-		var cmdB_stderr bytes.Buffer
-		cmdB.Stdout = &cmdB_stdout
-		cmdB.Stderr = &cmdB_stderr
-		sem_convert.Acquire()
-		cmdB_err := cmdB.Run()
-		sem_convert.Release()
+		cmdB := ToolCommand{Name: "convert", Args: []string{pp.PNG.Dark.Original, `-channel`, `rgba`, `-matte`, `-fill`, `rgba(40,40,86,1)`, `-fuzz`, `12%`, `-opaque`, `white`, `-flatten`, pp.PNG.Dark.Original}, Semaphore: sem_convert}
+		_, cmdB_err := runTool(ctx, cmdB)
 		if cmdB_err != nil {
 			log.Printf("failed to convert %v into %v due to error: %s\n", pp.PNG.Light.Original, pp.PNG.Dark.Original, cmdB_err)
+			if errors.Is(cmdB_err, errToolTimeout) {
+				failed = true
+				failPage(ctx, pp, c_journal_page_dark, cmdB_err)
+			}
 			return
 		}
 	}
@@ -441,11 +421,25 @@ func generateDarkThumbnails(ctx context.Context, pp PendingPage) {
 		}
 	}
 
+	journalPageStage(pp, c_journal_page_dark)
 }
 
 func performOcrOnPdf(ctx context.Context, pp PendingPage) {
 	defer wg_active_tasks.Done()
+	failed := false
+	completed := false
 	defer func() {
+		if failed {
+			return
+		}
+		pp = chooseBestText(pp)
+		pp = loadOcrWords(pp)
+		pp = gradeOcrQuality(ctx, pp)
+		pp_save(pp)
+		// the ocr stage is only journaled once the chosen text and its grade are saved with the page
+		if completed {
+			journalPageStage(pp, c_journal_page_ocr)
+		}
 		log.Printf("completed performOcrOnPdf now sending %v (%v.%v) -> ch_ConvertToJpg ", pp.PDFPath, pp.RecordIdentifier, pp.Identifier)
 		if ch_ConvertToJpg.CanWrite() {
 			err := ch_ConvertToJpg.Write(pp)
@@ -456,34 +450,44 @@ func performOcrOnPdf(ctx context.Context, pp PendingPage) {
 		}
 	}()
 
+	textLayerErr := extractPageTextLayer(ctx, pp)
+	if textLayerErr != nil {
+		log.Printf("page %v will only use its OCR text because its text layer cannot be extracted due to error %v", pp.Identifier, textLayerErr)
+	}
+	pp.OCRSkipped, pp.OCRReason = textLayerSkipsOcr(ctx, pp)
+	if pp.OCRSkipped {
+		log.Printf("skipping performOcrOnPdf(%v.%v) because %v", pp.RecordIdentifier, pp.Identifier, pp.OCRReason)
+		completed = true
+		return
+	}
+	log.Printf("page %v needs OCR because %v", pp.Identifier, pp.OCRReason)
 	if ok, err := fileHasData(pp.OCRTextPath); !ok || err != nil {
 		/*
-			tesseract REPLACE_WITH_FILE_PATH REPLACE_WITH_TEXT_OUTPUT_FILE_PATH -l eng --psm 1
+			tesseract REPLACE_WITH_FILE_PATH REPLACE_WITH_TEXT_OUTPUT_FILE_PATH -l eng --psm 1 txt tsv
 		*/
 		ocrStat, ppOcrPathErr := os.Stat(pp.OCRTextPath)
 		if (ppOcrPathErr == nil || !os.IsNotExist(ppOcrPathErr)) && ocrStat.Size() > 0 {
 			ocrText, ocrTextErr := os.ReadFile(pp.OCRTextPath)
 			if ocrTextErr != nil && len(string(ocrText)) > 6 {
 				log.Printf("finished performOcrOnPdf(%v.%v) because the file %v already has %d bytes inside it!", pp.RecordIdentifier, pp.Identifier, pp.OCRTextPath, ocrStat.Size())
+				completed = true
 				return
 			}
 		}
-		cmd := exec.Command(m_required_binaries["tesseract"], pp.PNG.Light.Original, strings.TrimSuffix(pp.OCRTextPath, ".txt"), `-l`, `eng`, `--psm`, `1`)
-		var cmd_stdout bytes.Buffer
-		var cmd_stderr bytes.Buffer
-		cmd.Stdout = &cmd_stdout
-		cmd.Stderr = &cmd_stderr
-		log.Printf("started performOcrOnPdf(%v.%v) = %v (WAITING)", pp.RecordIdentifier, pp.Identifier, pp.PDFPath)
-		sem_tesseract.Acquire()
-		log.Printf("running performOcrOnPdf(%v.%v) = %v", pp.RecordIdentifier, pp.Identifier, pp.PDFPath)
-		cmd_err := cmd.Run()
-		sem_tesseract.Release()
+		cmd := ToolCommand{Name: "tesseract", Args: []string{pp.PNG.Light.Original, strings.TrimSuffix(pp.OCRTextPath, ".txt"), `-l`, `eng`, `--psm`, `1`, `txt`, `tsv`}, Semaphore: sem_tesseract}
+		log.Printf("started performOcrOnPdf(%v.%v) = %v", pp.RecordIdentifier, pp.Identifier, pp.PDFPath)
+		cmd_result, cmd_err := runTool(ctx, cmd)
 		log.Printf("completed performOcrOnPdf(%v.%v) = %v", pp.RecordIdentifier, pp.Identifier, pp.PDFPath)
 		if cmd_err != nil {
-			log.Printf("Command `tesseract %v %v -l eng --psm 1` failed with error: %s\n\n\tSTDERR = %v\n\tSTDOUT = %v\n", pp.PNG.Light.Original, pp.OCRTextPath, cmd_err, cmd_stderr.String(), cmd_stdout.String())
+			log.Printf("Command `%v` failed with error: %s\n\n\tSTDERR = %v\n\tSTDOUT = %v\n", cmd, cmd_err, cmd_result.Stderr, cmd_result.Stdout)
+			if errors.Is(cmd_err, errToolTimeout) {
+				failed = true
+				failPage(ctx, pp, c_journal_page_ocr, cmd_err)
+			}
 			return
 		}
 	}
+	completed = true
 }
 
 func convertPngToJpg(ctx context.Context, pp PendingPage) {
@@ -511,15 +515,21 @@ func convertPngToJpg(ctx context.Context, pp PendingPage) {
 		pp.PNG.Dark.Small:     pp.JPEG.Dark.Small,
 		pp.PNG.Dark.Social:    pp.JPEG.Dark.Social,
 	}
+	failures := 0
 	for png, jpeg := range files {
 		f, e1 := os.Open(png)
 		if e1 != nil {
 			log.Printf("failed to convertAndOptimizePNG for file %v due to error %v", png, e1)
+			if !os.IsNotExist(e1) {
+				failures++
+			}
 			continue
 		}
 		e2 := convertAndOptimizePNG(f, jpeg)
+		f.Close()
 		if e2 != nil {
 			log.Printf("failed to convertAndOptimizePNG(%v) due to error %v", png, e2)
+			failures++
 			continue
 		}
 
@@ -532,4 +542,7 @@ func convertPngToJpg(ctx context.Context, pp PendingPage) {
 		}
 	}
 
+	if failures == 0 {
+		journalPageStage(pp, c_journal_page_jpg)
+	}
 }
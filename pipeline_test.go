@@ -0,0 +1,247 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`encoding/json`
+	`fmt`
+	`os`
+	`path/filepath`
+	`reflect`
+	`strings`
+	`testing`
+)
+
+func resetJournalState() {
+	m_journal_checksums = make(map[string]string)
+	m_journal_records = make(map[string]*JournalState)
+	m_journal_pages = make(map[string]*JournalState)
+	m_journal_contents = make(map[string]string)
+}
+
+func Test_validatePdf(t *testing.T) {
+	resetJournalState()
+	defer resetJournalState()
+
+	const broken = "%PDF-1.4 truncated download"
+	tests := []struct {
+		name      string
+		pages     int // pages of the download, 0 for a broken download
+		gsPages   int // pages of the PDF that gs writes, 0 for a broken rewrite
+		repair    string
+		pageCount int
+		commands  []string
+		wantErr   bool
+	}{
+		{
+			name:      "pdfcpu optimize",
+			pages:     3,
+			repair:    c_repair_pdfcpu_optimize,
+			pageCount: 3,
+		},
+		{
+			name:      "gs rewrite",
+			gsPages:   2,
+			repair:    c_repair_gs_rewrite,
+			pageCount: 2,
+			commands: []string{
+				"gs -q -dNOPAUSE -dBATCH -dSAFER -sDEVICE=pdfwrite -dCompatibilityLevel=1.7 -o {dir}/a.pdf.normalize.pdf {dir}/original.pdf",
+			},
+		},
+		{
+			name: "nothing is valid",
+			commands: []string{
+				"gs -q -dNOPAUSE -dBATCH -dSAFER -sDEVICE=pdfwrite -dCompatibilityLevel=1.7 -o {dir}/a.pdf.normalize.pdf {dir}/original.pdf",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			fake := useFakeToolRunner(t, map[string]func(cmd ToolCommand) (ToolResult, error){
+				"gs": func(cmd ToolCommand) (ToolResult, error) {
+					output := cmd.Args[len(cmd.Args)-2]
+					if tt.gsPages == 0 {
+						return ToolResult{}, os.WriteFile(output, []byte(broken), 0644)
+					}
+					writeTestPdf(t, output, tt.gsPages)
+					return ToolResult{}, nil
+				},
+			})
+			record := ResultData{
+				Identifier: NewStableIdentifier(9, tt.name),
+				DataDir:    dir,
+				PDFPath:    filepath.Join(dir, "a.pdf"),
+				RecordPath: filepath.Join(dir, "record.json"),
+			}
+			if tt.pages == 0 {
+				if err := os.WriteFile(record.PDFPath, []byte(broken), 0644); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				writeTestPdf(t, record.PDFPath, tt.pages)
+			}
+			download, err := os.ReadFile(record.PDFPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = validatePdf(context.Background(), record)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validatePdf() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var want []string
+			for _, command := range tt.commands {
+				want = append(want, strings.ReplaceAll(command, "{dir}", dir))
+			}
+			if got := fake.Commands(); !reflect.DeepEqual(got, want) {
+				t.Errorf("validatePdf() ran %v, want %v", got, want)
+			}
+			if original, _ := os.ReadFile(filepath.Join(dir, c_original_pdf)); string(original) != string(download) {
+				t.Errorf("Expected the download to be kept as %v, but got %q", c_original_pdf, original)
+			}
+			if validated := journalRecordHasStage(record.Identifier, c_journal_record_validated); validated == tt.wantErr {
+				t.Errorf("Expected the validated stage to be journaled = %v", !tt.wantErr)
+			}
+			rd, err := ReadResultDataFromJson(record.RecordPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rd.Normalization == nil || rd.Normalization.Repair != tt.repair || rd.Normalization.PageCount != tt.pageCount {
+				t.Fatalf("Expected record.json to record the %q repair with %d pages, but got %+v", tt.repair, tt.pageCount, rd.Normalization)
+			}
+			if tt.wantErr {
+				return
+			}
+			if info, err := validatePdfFile(context.Background(), record.PDFPath); err != nil || info.PageCount != tt.pageCount {
+				t.Errorf("Expected %v to be a valid PDF with %d pages, but got %+v and error %v", record.PDFPath, tt.pageCount, info, err)
+			}
+		})
+	}
+}
+
+func Test_performOcrOnPdf(t *testing.T) {
+	resetJournalState()
+	defer resetJournalState()
+
+	useTestDictionary(t, "top", "secret", "memorandum", "for", "the", "record")
+	const text = "TOP SECRET memorandum for the record"
+	fake := useFakeToolRunner(t, map[string]func(cmd ToolCommand) (ToolResult, error){
+		"tesseract": func(cmd ToolCommand) (ToolResult, error) {
+			if err := os.WriteFile(cmd.Args[1]+".tsv", []byte(testTesseractTsv), 0644); err != nil {
+				return ToolResult{}, err
+			}
+			return ToolResult{}, os.WriteFile(cmd.Args[1]+".txt", []byte(text), 0644)
+		},
+		"pdftotext": func(cmd ToolCommand) (ToolResult, error) {
+			// a scanned page has no text layer, so pdftotext only finds the page break
+			return ToolResult{}, os.WriteFile(cmd.Args[len(cmd.Args)-1], []byte("\f"), 0644)
+		},
+	})
+	pagesDir := t.TempDir()
+	pp := PendingPage{
+		Identifier:       "page",
+		RecordIdentifier: "record",
+		PageNumber:       1,
+		PDFPath:          filepath.Join(pagesDir, "page.000001.pdf"),
+		OCRTextPath:      filepath.Join(pagesDir, "ocr.000001.txt"),
+		OCRTSVPath:       filepath.Join(pagesDir, "ocr.000001.tsv"),
+		TextLayerPath:    filepath.Join(pagesDir, "text.000001.txt"),
+		ManifestPath:     filepath.Join(pagesDir, "page.000001.json"),
+		PNG:              PNG{Light: Images{Original: filepath.Join(pagesDir, "page.light.000001.original.png")}},
+	}
+
+	for _, name := range []string{"runs tesseract", "keeps the existing text"} {
+		t.Run(name, func(t *testing.T) {
+			wg_active_tasks.Add(1)
+			performOcrOnPdf(context.Background(), pp)
+			got, err := ch_ConvertToJpg.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sent, ok := got.(PendingPage); !ok || sent.TextSource != c_text_source_ocr || sent.BestTextPath() != pp.OCRTextPath {
+				t.Errorf("Expected the page to be sent on with its OCR text as the best text, but got %+v", got)
+			}
+			if sent, _ := got.(PendingPage); len(sent.OCRWords) != 2 {
+				t.Errorf("Expected the words of the tesseract TSV in the page, but got %+v", sent.OCRWords)
+			}
+			if sent, _ := got.(PendingPage); sent.OCRQuality == nil || sent.OCRQuality.Grade != c_ocr_grade_good {
+				t.Errorf("Expected the OCR of the page to be graded %v, but got %+v", c_ocr_grade_good, sent.OCRQuality)
+			}
+			if got, _ := os.ReadFile(pp.OCRTextPath); string(got) != text {
+				t.Errorf("Expected %v to contain %q, but got %q", pp.OCRTextPath, text, got)
+			}
+			if !journalHasStageLocked(JournalEntry{Kind: c_journal_kind_page, Key: pp.RecordIdentifier, PageNumber: pp.PageNumber, Stage: c_journal_page_ocr}) {
+				t.Errorf("Expected the %v stage of page %v to be journaled", c_journal_page_ocr, pp.Identifier)
+			}
+		})
+	}
+	if got := fake.Commands(); len(got) != 2 || !strings.HasPrefix(got[0], "pdftotext ") || !strings.HasPrefix(got[1], "tesseract ") {
+		t.Errorf("Expected pdftotext and tesseract to run once, but got %v", got)
+	}
+}
+
+func Test_performOcrOnPdfTimeout(t *testing.T) {
+	resetJournalState()
+	defer resetJournalState()
+
+	useFakeToolRunner(t, map[string]func(cmd ToolCommand) (ToolResult, error){
+		"tesseract": func(cmd ToolCommand) (ToolResult, error) {
+			return ToolResult{}, fmt.Errorf("%w: `%v` ran longer than %v", errToolTimeout, cmd, cmd.Timeout)
+		},
+	})
+	pagesDir := t.TempDir()
+	pp := PendingPage{
+		Identifier:       "timeout-page",
+		RecordIdentifier: "timeout-record",
+		PageNumber:       1,
+		OCRTextPath:      filepath.Join(pagesDir, "ocr.000001.txt"),
+		ManifestPath:     filepath.Join(pagesDir, "page.000001.json"),
+	}
+
+	// the pipeline adds one task for every stage from the ocr stage onwards
+	before := wg_active_tasks.Count()
+	wg_active_tasks.Add(len(sl_journal_page_stages) - 3)
+	performOcrOnPdf(context.Background(), pp)
+	if after := wg_active_tasks.Count(); after != before {
+		t.Errorf("Expected every task of the page to be released, but %d are left", after-before)
+	}
+	if count := ch_ConvertToJpg.Count(); count != 0 {
+		t.Errorf("Expected the failed page not to be sent to the next stage, but ch_ConvertToJpg has %d", count)
+	}
+
+	manifest, err := os.ReadFile(pp.ManifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failed PendingPage
+	if err = json.Unmarshal(manifest, &failed); err != nil {
+		t.Fatal(err)
+	}
+	if !failed.Failed || failed.FailedStage != c_journal_page_ocr || !strings.Contains(failed.FailedReason, "ran longer than") {
+		t.Errorf("Expected the manifest to mark the page failed at the %v stage, but got %v %v %v", c_journal_page_ocr, failed.Failed, failed.FailedStage, failed.FailedReason)
+	}
+	ra := recordAggregate(pp.RecordIdentifier)
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	if _, found := ra.failed[pp.PageNumber]; !found {
+		t.Errorf("Expected page %d to be aggregated as failed", pp.PageNumber)
+	}
+}
@@ -19,17 +19,41 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 
-	"github.com/tealeg/xlsx"
+	"github.com/xuri/excelize/v2"
 )
 
+// loadFile picks the loader for the -file by its extension, or loadDirectory for a directory or glob.
+func loadFile(ctx context.Context, filename string, callback CallbackFunc) error {
+	if isDirectoryInput(filename) {
+		return loadDirectory(ctx, filename, callback) // walk the PDFs
+	} else if strings.Contains(filename, ".jsonl") || strings.Contains(filename, ".ndjson") {
+		return loadJsonl(ctx, filename, callback) // parse the file (or .gz)
+	} else if strings.Contains(filename, ".csv") || strings.Contains(filename, ".psv") {
+		return loadCsv(ctx, filename, callback) // parse the file
+	} else if strings.Contains(filename, ".xlsx") {
+		return loadXlsx(ctx, filename, callback) // parse the file
+	}
+	return fmt.Errorf("unable to parse file %v", filename)
+}
+
 func loadCsv(ctx context.Context, filename string, callback CallbackFunc) error {
 	file, openErr := os.Open(filename)
 	if openErr != nil {
@@ -43,7 +67,8 @@ func loadCsv(ctx context.Context, filename string, callback CallbackFunc) error
 		}
 	}(file)
 	bufferedReader := bufio.NewReaderSize(file, reader_buffer_bytes)
-	reader := csv.NewReader(bufferedReader)
+	source := newCsvSourceReader(bufferedReader)
+	reader := csv.NewReader(source)
 	if strings.HasSuffix(filename, ".psv") {
 		reader.Comma = '|'
 	}
@@ -55,117 +80,464 @@ func loadCsv(ctx context.Context, filename string, callback CallbackFunc) error
 	}
 	log.Printf("headerFields = %v", strings.Join(headerFields, ","))
 	row := make(chan []Column, channel_buffer_size)
-	totalRows, rowWg := atomic.Uint32{}, sync.WaitGroup{}
+	totalRows := atomic.Uint32{}
 	done := make(chan struct{})
+	reject := rejectorFromContext(ctx)
 	go ReceiveRows(ctx, row, filename, callback, done)
 	for {
 		rowFields, readerErr := reader.Read()
-		if readerErr != nil {
-			log.Printf("skipping row due to error %v with data %v", readerErr, rowFields)
+		if readerErr == io.EOF {
 			break
 		}
+		var parseErr *csv.ParseError
+		if errors.As(readerErr, &parseErr) {
+			// the reader resumes on the next line after a malformed row
+			reject(filename, parseErr.StartLine, c_reject_csv_parse, parseErr, source.Text(parseErr.StartLine, parseErr.Line))
+			source.Forget(parseErr.Line + 1)
+			continue
+		} else if readerErr != nil {
+			log.Printf("stopped reading %v due to error %v with data %v", filename, readerErr, rowFields)
+			break
+		}
+		rowNumber, _ := reader.FieldPos(0)
+		source.Forget(rowNumber)
+		rowData, rowErr := ProcessRow(headerFields, rowFields, rowNumber)
+		if rowErr != nil {
+			reject(filename, rowNumber, c_reject_blank_first_column, rowErr, strings.Join(rowFields, string(reader.Comma)))
+			continue
+		} else if rowData == nil {
+			continue
+		}
 		totalRows.Add(1)
-		rowWg.Add(1)
-		go ProcessRow(headerFields, rowFields, &rowWg, row)
+		row <- rowData
 	}
 
-	rowWg.Wait()
 	close(row)
 	<-done
 	log.Printf("totalRows = %d", totalRows.Load())
 	return nil
 }
 
+// loadXlsx streams the rows of the -sheet of the workbook (the first sheet by default) or of every sheet when
+// -all-sheets is set. Each row is tagged with a sheet column holding the name of the sheet that it came from.
+// csvSourceReader keeps the source text of the lines that the csv.Reader has read but not yet parsed, so that a
+// malformed row can be written to rejected.csv as it appears in the file rather than as the fields parsed before the
+// error. Lines are numbered from 1 like the csv.ParseError and are kept until they are forgotten.
+type csvSourceReader struct {
+	reader  io.Reader
+	line    int
+	oldest  int
+	partial []byte
+	lines   map[int][]byte
+}
+
+func newCsvSourceReader(reader io.Reader) *csvSourceReader {
+	return &csvSourceReader{reader: reader, line: 1, oldest: 1, lines: make(map[int][]byte)}
+}
+
+func (r *csvSourceReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	chunk := p[:n]
+	for len(chunk) > 0 {
+		end := bytes.IndexByte(chunk, '\n')
+		if end < 0 {
+			r.partial = append(r.partial, chunk...)
+			break
+		}
+		r.partial = append(r.partial, chunk[:end]...)
+		r.lines[r.line] = bytes.TrimSuffix(r.partial, []byte("\r"))
+		r.partial = nil
+		r.line++
+		chunk = chunk[end+1:]
+	}
+	if err == io.EOF && len(r.partial) > 0 {
+		r.lines[r.line] = r.partial
+		r.partial = nil
+		r.line++
+	}
+	return n, err
+}
+
+// Text returns the source lines from start to end joined by newlines.
+func (r *csvSourceReader) Text(start int, end int) string {
+	var lines [][]byte
+	for line := start; line <= end; line++ {
+		if text, found := r.lines[line]; found {
+			lines = append(lines, text)
+		}
+	}
+	return string(bytes.Join(lines, []byte("\n")))
+}
+
+// Forget drops the source lines before the line numbered before.
+func (r *csvSourceReader) Forget(before int) {
+	for ; r.oldest < before; r.oldest++ {
+		delete(r.lines, r.oldest)
+	}
+}
+
 func loadXlsx(ctx context.Context, filename string, callback CallbackFunc) error {
-	file, err := xlsx.OpenFile(filename)
+	file, err := excelize.OpenFile(filename)
 	if err != nil {
 		log.Printf("cant open the file because of err: %v", err)
 		return err
 	}
-	sheet := file.Sheets[0]
-	headerFields := make([]string, 0, len(sheet.Rows[0].Cells))
-	for _, cell := range sheet.Rows[0].Cells {
-		if len(cell.String()) > 0 {
-			headerFields = append(headerFields, cell.String())
+	defer func(file *excelize.File) {
+		closeErr := file.Close()
+		if closeErr != nil {
+			log.Printf("failed to close the file %v caused error %v", filename, closeErr)
 		}
+	}(file)
+
+	sheets, sheetErr := xlsxSheets(file.GetSheetList(), *flag_s_sheet, *flag_b_all_sheets)
+	if sheetErr != nil {
+		log.Printf("cant select the sheet because of err: %v", sheetErr)
+		return sheetErr
 	}
-	log.Printf("headerFields = %v", strings.Join(headerFields, ","))
+
 	row := make(chan []Column, channel_buffer_size)
-	totalRows, rowWg := atomic.Uint32{}, sync.WaitGroup{}
+	totalRows := atomic.Uint32{}
 	done := make(chan struct{})
+	reject := rejectorFromContext(ctx)
 	go ReceiveRows(ctx, row, filename, callback, done)
-	for _, sheetRow := range sheet.Rows[1:] {
-		rowFields := make([]string, 0, len(sheetRow.Cells))
-		for _, cell := range sheetRow.Cells {
-			rowFields = append(rowFields, cell.String())
+	for _, sheet := range sheets {
+		readErr := func() error {
+			rows, rowsErr := file.Rows(sheet)
+			if rowsErr != nil {
+				return rowsErr
+			}
+			defer rows.Close()
+
+			var headerFields []string
+			var rowNumber int
+			for rows.Next() {
+				rowNumber++
+				rowFields, columnsErr := rows.Columns()
+				if columnsErr != nil {
+					log.Printf("skipping row of sheet %v due to error %v", sheet, columnsErr)
+					continue
+				}
+				if headerFields == nil {
+					headerFields = rowFields
+					log.Printf("headerFields of sheet %v = %v", sheet, strings.Join(headerFields, ","))
+					continue
+				}
+				rowData, rowErr := ProcessRow(headerFields, rowFields, rowNumber, Column{Header: c_column_sheet, Value: sheet})
+				if rowErr != nil {
+					reject(filename+"#"+sheet, rowNumber, c_reject_blank_first_column, rowErr, strings.Join(rowFields, ","))
+					continue
+				} else if rowData == nil {
+					continue
+				}
+				totalRows.Add(1)
+				row <- rowData
+			}
+			return rows.Error()
+		}()
+		if readErr != nil {
+			log.Printf("stopped reading sheet %v of %v due to error %v", sheet, filename, readErr)
+		}
+	}
+	close(row)
+	<-done
+	log.Printf("totalRows = %d", totalRows.Load())
+	return nil
+}
+
+// xlsxSheets returns the sheets to load. The selection is either the name of a sheet or its 1-based position, and a
+// blank selection is the first sheet.
+func xlsxSheets(sheetList []string, selection string, allSheets bool) ([]string, error) {
+	if len(sheetList) == 0 {
+		return nil, fmt.Errorf("the workbook does not have any sheets")
+	}
+	if allSheets {
+		return sheetList, nil
+	}
+	if len(selection) == 0 {
+		return sheetList[:1], nil
+	}
+	for _, sheet := range sheetList {
+		if sheet == selection {
+			return []string{sheet}, nil
+		}
+	}
+	position, err := strconv.Atoi(selection)
+	if err == nil && position >= 1 && position <= len(sheetList) {
+		return []string{sheetList[position-1]}, nil
+	}
+	return nil, fmt.Errorf("the workbook does not have a sheet named %v, it has %v", selection, strings.Join(sheetList, ", "))
+}
+
+// loadDirectory walks a directory, or every match of a glob, for PDF files that are already on disk. Each PDF becomes a
+// row made out of its sidecar metadata (a.pdf.json, a.json, a.pdf.csv or a.csv next to a.pdf) plus a local_path column
+// that tells processRecord to copy the file instead of downloading it.
+func loadDirectory(ctx context.Context, pattern string, callback CallbackFunc) error {
+	pdfs, findErr := findPdfs(pattern)
+	if findErr != nil {
+		log.Printf("cant find the PDFs in %v because of err: %v", pattern, findErr)
+		return findErr
+	}
+	if len(pdfs) == 0 {
+		return fmt.Errorf("no PDF files were found in %v", pattern)
+	}
+	row := make(chan []Column, channel_buffer_size)
+	totalRows := atomic.Uint32{}
+	done := make(chan struct{})
+	go ReceiveRows(ctx, row, pattern, callback, done)
+	for i, pdf := range pdfs {
+		rowData, sidecarErr := loadSidecar(pdf)
+		if sidecarErr != nil {
+			log.Printf("skipping the sidecar metadata of %v due to error %v", pdf, sidecarErr)
 		}
 		totalRows.Add(1)
-		rowWg.Add(1)
-		go ProcessRow(headerFields, rowFields, &rowWg, row)
+		row <- append(rowData, Column{Header: c_column_local_path, Value: pdf}, Column{Header: c_column_row_number, Value: strconv.Itoa(i + 1)})
 	}
-	rowWg.Wait()
 	close(row)
 	<-done
 	log.Printf("totalRows = %d", totalRows.Load())
 	return nil
 }
 
-func ProcessRow(headerFields []string, rowFields []string, rowWg *sync.WaitGroup, row chan []Column) {
-	defer rowWg.Done()
-	var d = map[string]string{}
-	if len(headerFields) != len(rowFields) {
-		if len(headerFields) < len(rowFields) {
-			for i, r := range rowFields {
-				if i >= len(headerFields) || len(r) == 0 {
-					continue
+// isDirectoryInput returns true when the -file should be loaded with loadDirectory.
+func isDirectoryInput(in string) bool {
+	return IsDir(in) || strings.ContainsAny(in, "*?[")
+}
+
+func findPdfs(pattern string) ([]string, error) {
+	matches := []string{pattern}
+	if strings.ContainsAny(pattern, "*?[") {
+		var globErr error
+		matches, globErr = filepath.Glob(pattern)
+		if globErr != nil {
+			return nil, globErr
+		}
+	}
+	var pdfs []string
+	for _, match := range matches {
+		walkErr := filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && strings.EqualFold(filepath.Ext(path), ".pdf") {
+				absolute, absErr := filepath.Abs(path)
+				if absErr != nil {
+					return absErr
 				}
-				d[headerFields[i]] = r
+				pdfs = append(pdfs, absolute)
+			}
+			return nil
+		})
+		if walkErr != nil {
+			return nil, walkErr
+		}
+	}
+	sort.Strings(pdfs)
+	return pdfs, nil
+}
+
+// loadSidecar returns the metadata found next to the pdf as columns, or no columns when there isn't a sidecar file.
+func loadSidecar(pdf string) ([]Column, error) {
+	base := strings.TrimSuffix(pdf, filepath.Ext(pdf))
+	for _, sidecar := range []string{pdf + ".json", base + ".json", pdf + ".csv", base + ".csv"} {
+		contents, readErr := os.ReadFile(sidecar)
+		if os.IsNotExist(readErr) {
+			continue
+		} else if readErr != nil {
+			return nil, readErr
+		}
+
+		var columns []Column
+		if strings.HasSuffix(sidecar, ".json") {
+			var jsonErr error
+			columns, jsonErr = jsonColumns(contents)
+			if jsonErr != nil {
+				return nil, fmt.Errorf("cannot parse %v due to error %v", sidecar, jsonErr)
 			}
 		} else {
-			for i, h := range headerFields {
-				if i >= len(rowFields) || len(h) == 0 {
-					continue
+			records, csvErr := csv.NewReader(strings.NewReader(string(contents))).ReadAll()
+			if csvErr != nil {
+				return nil, fmt.Errorf("cannot parse %v due to error %v", sidecar, csvErr)
+			}
+			if len(records) < 2 {
+				return nil, fmt.Errorf("expected a header and a value row in %v", sidecar)
+			}
+			for i, header := range records[0] {
+				if i < len(records[1]) && len(header) > 0 {
+					columns = append(columns, Column{Header: header, Value: records[1][i]})
 				}
-				d[h] = rowFields[i]
 			}
 		}
+		sort.Slice(columns, func(i, j int) bool {
+			return columns[i].Header < columns[j].Header
+		})
+		return columns, nil
 	}
-	var rowData = []Column{}
-	if len(d) > 0 {
-		for h, v := range d {
-			rowData = append(rowData, Column{Header: h, Value: v})
+	return nil, nil
+}
+
+// loadJsonl reads a JSON Lines (NDJSON) file where every line is an object of metadata fields, optionally gzipped.
+func loadJsonl(ctx context.Context, filename string, callback CallbackFunc) error {
+	file, openErr := os.Open(filename)
+	if openErr != nil {
+		log.Printf("cant open the file because of err: %v", openErr)
+		return openErr
+	}
+	defer func(file *os.File) {
+		closeErr := file.Close()
+		if closeErr != nil {
+			log.Fatalf("failed to close the file %v caused error %v", filename, closeErr)
 		}
-	} else {
-		for i := 0; i < len(rowFields); i++ {
-			value := rowFields[i]
-			if i == 0 && len(value) == 0 {
-				return
+	}(file)
+	var input io.Reader = file
+	if strings.HasSuffix(filename, ".gz") {
+		gzipReader, gzipErr := gzip.NewReader(file)
+		if gzipErr != nil {
+			log.Printf("cant open the gzip reader because of err: %v", gzipErr)
+			return gzipErr
+		}
+		defer gzipReader.Close()
+		input = gzipReader
+	}
+	bufferedReader := bufio.NewReaderSize(input, reader_buffer_bytes)
+	row := make(chan []Column, channel_buffer_size)
+	totalRows, lineNumber := atomic.Uint32{}, 0
+	done := make(chan struct{})
+	reject := rejectorFromContext(ctx)
+	go ReceiveRows(ctx, row, filename, callback, done)
+	for {
+		line, readerErr := bufferedReader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			lineNumber++
+			rowData, jsonErr := jsonColumns(line)
+			if jsonErr != nil {
+				reject(filename, lineNumber, c_reject_invalid_json, jsonErr, string(bytes.TrimSpace(line)))
+			} else if len(rowData) > 0 {
+				totalRows.Add(1)
+				row <- append(rowData, Column{Header: c_column_row_number, Value: strconv.Itoa(lineNumber)})
 			}
-			if len(headerFields) < i {
-				log.Printf("skipping rowField %v due to headerFields not matching up properly", rowFields[i])
-				continue
+		}
+		if readerErr != nil {
+			if readerErr != io.EOF {
+				log.Printf("stopped reading %v due to error %v", filename, readerErr)
 			}
-			rowData = append(rowData, Column{headerFields[i], value})
+			break
+		}
+	}
+	close(row)
+	<-done
+	log.Printf("totalRows = %d", totalRows.Load())
+	return nil
+}
+
+// jsonColumns turns a JSON object into columns, skipping null values and formatting everything else as a string.
+func jsonColumns(contents []byte) ([]Column, error) {
+	var fields map[string]interface{}
+	err := json.Unmarshal(contents, &fields)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]Column, 0, len(fields))
+	for header, value := range fields {
+		if value == nil {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			columns = append(columns, Column{Header: header, Value: v})
+		case float64:
+			columns = append(columns, Column{Header: header, Value: strconv.FormatFloat(v, 'f', -1, 64)})
+		case bool:
+			columns = append(columns, Column{Header: header, Value: strconv.FormatBool(v)})
+		default:
+			encoded, _ := json.Marshal(v)
+			columns = append(columns, Column{Header: header, Value: string(encoded)})
 		}
 	}
-	row <- rowData
+	sort.Slice(columns, func(i, j int) bool {
+		return columns[i].Header < columns[j].Header
+	})
+	return columns, nil
 }
 
+// ProcessRow pairs the rowFields with the headerFields by position, keeping the order of the columns, and tags the row
+// with its rowNumber in the source file. Blank rows are skipped with a nil row and an error is returned for rows that
+// are missing their first field.
+func ProcessRow(headerFields []string, rowFields []string, rowNumber int, extra ...Column) ([]Column, error) {
+	if len(strings.TrimSpace(strings.Join(rowFields, ""))) == 0 {
+		return nil, nil
+	}
+	if len(rowFields[0]) == 0 {
+		return nil, fmt.Errorf("row %d does not have a value in its first field", rowNumber)
+	}
+	if len(rowFields) > len(headerFields) {
+		log.Printf("row %d has %d fields but there are only %d headers, ignoring %v", rowNumber, len(rowFields), len(headerFields), rowFields[len(headerFields):])
+	}
+	rowData := make([]Column, 0, len(headerFields)+len(extra)+1)
+	for i, header := range headerFields {
+		if len(header) == 0 {
+			continue
+		}
+		var value string
+		if i < len(rowFields) {
+			value = rowFields[i]
+		}
+		rowData = append(rowData, Column{Header: header, Value: value})
+	}
+	rowData = append(rowData, extra...)
+	return append(rowData, Column{Header: c_column_row_number, Value: strconv.Itoa(rowNumber)}), nil
+}
+
+// ReceiveRows hands the rows to the callback in the order that they were read using -limit workers. The callback only
+// dispatches the record into the pipeline, so the row_number of a record is what keeps it in the order of the file.
 func ReceiveRows(ctx context.Context, row chan []Column, filename string, callback CallbackFunc, done chan struct{}) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case populatedRow, ok := <-row:
-			if !ok {
-				done <- struct{}{}
-				return
-			}
-			ctx := context.WithValue(ctx, CtxKey("csv_file"), filename)
-			callbackErr := callback(ctx, populatedRow)
-			if callbackErr != nil {
-				log.Printf("failed to insert row %v with error %v", populatedRow, callbackErr)
+	workers := channel_buffer_size
+	if workers < 1 {
+		workers = 1
+	}
+	ctx = context.WithValue(ctx, CtxKey("csv_file"), filename)
+	reject := rejectorFromContext(ctx)
+	workerWg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		workerWg.Add(1)
+		go func() {
+			defer workerWg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case populatedRow, ok := <-row:
+					if !ok {
+						return
+					}
+					callbackErr := callback(ctx, populatedRow)
+					if callbackErr != nil {
+						reason := c_reject_processing
+						var rejection RowRejection
+						if errors.As(callbackErr, &rejection) {
+							reason = rejection.Reason
+						}
+						number, _ := strconv.Atoi(rowNumber(populatedRow))
+						reject(filename, number, reason, callbackErr, rawRow(populatedRow))
+					}
+				}
 			}
+		}()
+	}
+	workerWg.Wait()
+	if ctx.Err() != nil {
+		// the loader is still writing rows, keep draining them so it can close the channel
+		for range row {
+		}
+	}
+	done <- struct{}{}
+}
+
+// rowNumber returns the line number of the row in its source file, or a blank string when it doesn't have one.
+func rowNumber(row []Column) string {
+	for _, column := range row {
+		if column.Header == c_column_row_number {
+			return column.Value
 		}
 	}
+	return ""
 }
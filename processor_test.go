@@ -0,0 +1,315 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`bytes`
+	`reflect`
+	`compress/gzip`
+	`context`
+	`os`
+	`path/filepath`
+	`testing`
+
+	`github.com/xuri/excelize/v2`
+)
+
+func Test_loadDirectory(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.pdf":            "%PDF-1.4",
+		"a.json":           `{"title": "Alpha", "page_count": 2}`,
+		"nested/b.PDF":     "%PDF-1.4",
+		"nested/b.csv":     "title,agency\nBravo,CIA\n",
+		"nested/c.pdf":     "%PDF-1.4",
+		"nested/notes":     "not a pdf",
+		"other/d.pdf":      "%PDF-1.4",
+		"other/d.pdf.json": `{"pdf_url": "https://example.com/d.pdf"}`,
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		pattern string
+		want    map[string]map[string]string
+	}{
+		{
+			name:    "directory",
+			pattern: dir,
+			want: map[string]map[string]string{
+				"a.pdf": {"title": "Alpha", "page_count": "2"},
+				"b.PDF": {"title": "Bravo", "agency": "CIA"},
+				"c.pdf": {},
+				"d.pdf": {"pdf_url": "https://example.com/d.pdf"},
+			},
+		},
+		{
+			name:    "glob",
+			pattern: filepath.Join(dir, "nested", "*.pdf"),
+			want: map[string]map[string]string{
+				"c.pdf": {},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !isDirectoryInput(tt.pattern) {
+				t.Fatalf("Expected %v to be loaded as a directory", tt.pattern)
+			}
+			got := make(map[string]map[string]string)
+			err := loadDirectory(context.Background(), tt.pattern, func(ctx context.Context, row []Column) error {
+				values := make(map[string]string)
+				var localPath string
+				for _, column := range row {
+					if column.Header == c_column_local_path {
+						localPath = column.Value
+						continue
+					}
+					values[column.Header] = column.Value
+				}
+				got[filepath.Base(localPath)] = values
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("loadDirectory() loaded %v, want %v", got, tt.want)
+			}
+			for pdf, want := range tt.want {
+				for header, value := range want {
+					if got[pdf][header] != value {
+						t.Errorf("loadDirectory() %v[%v] = %v, want %v", pdf, header, got[pdf][header], value)
+					}
+				}
+			}
+		})
+	}
+
+	if err := loadDirectory(context.Background(), filepath.Join(dir, "*.txt"), nil); err == nil {
+		t.Errorf("Expected an error when the glob does not match any PDFs")
+	}
+}
+
+func Test_loadJsonl(t *testing.T) {
+	lines := []byte(`{"filename": "a.pdf", "page_count": 12, "released": true, "empty": null}
+
+not json
+{"filename": "b.pdf", "tags": ["x", "y"]}
+{"filename": "c.pdf"}`)
+	var gzipped bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipped)
+	if _, err := gzipWriter.Write(lines); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		filename string
+		contents []byte
+	}{
+		{name: "jsonl", filename: "records.jsonl", contents: lines},
+		{name: "ndjson gzip", filename: "records.ndjson.gz", contents: gzipped.Bytes()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.filename)
+			if err := os.WriteFile(path, tt.contents, 0600); err != nil {
+				t.Fatal(err)
+			}
+			var rows []map[string]string
+			err := loadJsonl(context.Background(), path, func(ctx context.Context, row []Column) error {
+				values := make(map[string]string)
+				for _, column := range row {
+					values[column.Header] = column.Value
+				}
+				rows = append(rows, values)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != 3 {
+				t.Fatalf("loadJsonl() returned %d rows, want 3: %v", len(rows), rows)
+			}
+			want := map[string]string{"filename": "a.pdf", "page_count": "12", "released": "true"}
+			for header, value := range want {
+				if rows[0][header] != value {
+					t.Errorf("loadJsonl() %v = %v, want %v", header, rows[0][header], value)
+				}
+			}
+			if _, found := rows[0]["empty"]; found {
+				t.Errorf("Expected null values to be skipped")
+			}
+			if rows[1]["tags"] != `["x","y"]` {
+				t.Errorf("Expected arrays to be kept as JSON, but got %v", rows[1]["tags"])
+			}
+		})
+	}
+}
+
+func Test_loadXlsx(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workbook.xlsx")
+	workbook := excelize.NewFile()
+	if err := workbook.SetSheetName("Sheet1", "2018"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := workbook.NewSheet("2021"); err != nil {
+		t.Fatal(err)
+	}
+	cells := map[string][][]interface{}{
+		"2018": {{"File Name", "Title"}, {"a.pdf", "Alpha"}, {"b.pdf"}},
+		"2021": {{"File Name", "Title"}, {"c.pdf", "Charlie"}},
+	}
+	for sheet, rows := range cells {
+		for i, row := range rows {
+			cell, _ := excelize.CoordinatesToCellName(1, i+1)
+			if err := workbook.SetSheetRow(sheet, cell, &row); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := workbook.SaveAs(path); err != nil {
+		t.Fatal(err)
+	}
+
+	sheet, allSheets := *flag_s_sheet, *flag_b_all_sheets
+	defer func() {
+		*flag_s_sheet, *flag_b_all_sheets = sheet, allSheets
+	}()
+
+	tests := []struct {
+		name      string
+		sheet     string
+		allSheets bool
+		want      map[string]string // File Name => sheet
+		wantErr   bool
+	}{
+		{name: "first sheet", want: map[string]string{"a.pdf": "2018", "b.pdf": "2018"}},
+		{name: "sheet by name", sheet: "2021", want: map[string]string{"c.pdf": "2021"}},
+		{name: "sheet by position", sheet: "2", want: map[string]string{"c.pdf": "2021"}},
+		{name: "all sheets", sheet: "2021", allSheets: true, want: map[string]string{"a.pdf": "2018", "b.pdf": "2018", "c.pdf": "2021"}},
+		{name: "missing sheet", sheet: "2023", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*flag_s_sheet, *flag_b_all_sheets = tt.sheet, tt.allSheets
+			got := make(map[string]string)
+			err := loadXlsx(context.Background(), path, func(ctx context.Context, row []Column) error {
+				values := make(map[string]string)
+				for _, column := range row {
+					values[column.Header] = column.Value
+				}
+				got[values["File Name"]] = values[c_column_sheet]
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadXlsx() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("loadXlsx() = %v, want %v", got, tt.want)
+			}
+			for fileName, sheet := range tt.want {
+				if got[fileName] != sheet {
+					t.Errorf("loadXlsx() %v came from sheet %v, want %v", fileName, got[fileName], sheet)
+				}
+			}
+		})
+	}
+}
+
+func Test_ProcessRow(t *testing.T) {
+	headers := []string{"File Name", "", "Title", "Pages"}
+	tests := []struct {
+		name   string
+		fields  []string
+		want    []Column
+		wantErr bool
+	}{
+		{
+			name:   "matching lengths",
+			fields: []string{"a.pdf", "x", "Alpha", "2"},
+			want:   []Column{{"File Name", "a.pdf"}, {"Title", "Alpha"}, {"Pages", "2"}, {c_column_row_number, "7"}},
+		},
+		{
+			name:   "short row",
+			fields: []string{"a.pdf", "x", "Alpha"},
+			want:   []Column{{"File Name", "a.pdf"}, {"Title", "Alpha"}, {"Pages", ""}, {c_column_row_number, "7"}},
+		},
+		{
+			name:   "long row",
+			fields: []string{"a.pdf", "x", "Alpha", "2", "extra"},
+			want:   []Column{{"File Name", "a.pdf"}, {"Title", "Alpha"}, {"Pages", "2"}, {c_column_row_number, "7"}},
+		},
+		{
+			name:    "blank first field",
+			fields:  []string{"", "x", "Alpha", "2"},
+			wantErr: true,
+		},
+		{
+			name:   "blank row",
+			fields: []string{"", " ", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ProcessRow(headers, tt.fields, 7)
+			if !reflect.DeepEqual(got, tt.want) || (err != nil) != tt.wantErr {
+				t.Errorf("ProcessRow() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_loadCsv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.csv")
+	contents := "filename,title\na.pdf,Alpha\nb.pdf,\"Bravo\nBravo\"\n,skipped\nc.pdf,Charlie\nd.pdf,Delta\n"
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	limit := channel_buffer_size
+	defer func() {
+		channel_buffer_size = limit
+	}()
+	channel_buffer_size = 1
+
+	var got []string
+	err := loadCsv(context.Background(), path, func(ctx context.Context, row []Column) error {
+		got = append(got, row[0].Value+"@"+rowNumber(row))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.pdf@2", "b.pdf@3", "c.pdf@6", "d.pdf@7"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadCsv() = %v, want %v", got, want)
+	}
+}
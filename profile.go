@@ -0,0 +1,265 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`embed`
+	`encoding/json`
+	`fmt`
+	`io/fs`
+	`log`
+	`os`
+	`path/filepath`
+	`sort`
+	`strconv`
+	`strings`
+
+	`gopkg.in/yaml.v3`
+)
+
+//go:embed profiles/*.yaml
+var fs_profiles embed.FS
+
+const c_default_profile = "default"
+
+// sl_profile_canonical_fields are the fields that processRecord understands, every other field of a Profile is copied
+// into the metadata of the record.
+var sl_profile_canonical_fields = []string{
+	"filename",
+	"title",
+	"collection",
+	"pdf_url",
+	"source_url",
+	"comments",
+	"record_number",
+	"to_name",
+	"from_name",
+	"agency",
+	"creation_date",
+	"release_date",
+	"checksum",
+}
+
+// Profile maps the headers of a metadata file onto the canonical fields used by processRecord.
+type Profile struct {
+	Name          string              `yaml:"name" json:"name"`
+	Match         []string            `yaml:"match" json:"match"`
+	Fields        map[string][]string `yaml:"fields" json:"fields"`
+	PageCount     []string            `yaml:"page_count" json:"page_count"`
+	PagesReleased []string            `yaml:"pages_released" json:"pages_released"`
+	DateFormats   []string            `yaml:"date_formats" json:"date_formats"`
+	RequiredDates []string            `yaml:"required_dates" json:"required_dates"`
+	URLTemplate   string              `yaml:"url_template" json:"url_template"`
+}
+
+// loadProfile returns the profile named by the -profile flag. The flag can be the name of a built-in profile from the
+// profiles directory or the path to a YAML or JSON file. When the flag is blank, the built-in profile with the longest
+// match contained in the filename of the -file is used, so jfk2021 wins over jfk, falling back to the default profile.
+func loadProfile(name string, filename string) (*Profile, error) {
+	if len(name) == 0 {
+		name = c_default_profile
+		builtIns, err := builtInProfiles()
+		if err != nil {
+			return nil, err
+		}
+		var best *Profile
+		bestLength := 0
+		for _, profile := range builtIns {
+			if length := profile.MatchLength(filename); length > bestLength {
+				best, bestLength = profile, length
+			}
+		}
+		if best != nil {
+			return best, nil
+		}
+	}
+
+	var contents []byte
+	var err error
+	if strings.ContainsRune(name, os.PathSeparator) || len(filepath.Ext(name)) > 0 {
+		contents, err = os.ReadFile(name)
+	} else {
+		contents, err = fs_profiles.ReadFile("profiles/" + name + ".yaml")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot load profile %v due to error %v", name, err)
+	}
+	return parseProfile(name, contents)
+}
+
+func builtInProfiles() ([]*Profile, error) {
+	paths, err := fs.Glob(fs_profiles, "profiles/*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	var profiles []*Profile
+	for _, path := range paths {
+		contents, readErr := fs_profiles.ReadFile(path)
+		if readErr != nil {
+			return nil, readErr
+		}
+		profile, parseErr := parseProfile(path, contents)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+func parseProfile(name string, contents []byte) (*Profile, error) {
+	var profile Profile
+	var err error
+	if strings.HasSuffix(strings.ToLower(name), ".json") {
+		err = json.Unmarshal(contents, &profile)
+	} else {
+		err = yaml.Unmarshal(contents, &profile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse profile %v due to error %v", name, err)
+	}
+	if len(profile.Fields) == 0 {
+		return nil, fmt.Errorf("profile %v does not define any fields", name)
+	}
+	if len(profile.Name) == 0 {
+		profile.Name = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	}
+	return &profile, nil
+}
+
+// importContext adds the filename of the -file and its Profile to the ctx used by the loaders.
+func importContext(ctx context.Context, filename string) (context.Context, error) {
+	ctx = context.WithValue(ctx, CtxKey("filename"), filename)
+	profile, err := loadProfile(*flag_s_profile, filename)
+	if err != nil {
+		return ctx, err
+	}
+	log.Printf("using the %v metadata profile for %v", profile.Name, filename)
+	return context.WithValue(ctx, CtxKey("profile"), profile), nil
+}
+
+func profileFromContext(ctx context.Context) (*Profile, error) {
+	profile, ok := ctx.Value(CtxKey("profile")).(*Profile)
+	if !ok || profile == nil {
+		return nil, fmt.Errorf("no profile was loaded into the context")
+	}
+	return profile, nil
+}
+
+// MatchLength returns the length of the longest of the Match substrings that is found in the filename, or 0 when the
+// profile does not match the filename.
+func (p *Profile) MatchLength(filename string) int {
+	filename = strings.ToLower(filepath.Base(filename))
+	longest := 0
+	for _, match := range p.Match {
+		if len(match) > longest && strings.Contains(filename, strings.ToLower(match)) {
+			longest = len(match)
+		}
+	}
+	return longest
+}
+
+// Values returns each field of the profile with the value of the first of its headers that has a value in the row.
+func (p *Profile) Values(row []Column) map[string]string {
+	columns := make(map[string]string, len(row))
+	for _, column := range row {
+		columns[column.Header] = strings.TrimSpace(column.Value)
+	}
+	values := make(map[string]string, len(p.Fields))
+	for field, headers := range p.Fields {
+		for _, header := range headers {
+			if value := columns[header]; len(value) > 0 {
+				values[field] = value
+				break
+			}
+		}
+	}
+	return values
+}
+
+// TotalPages adds up the PageCount headers of the row, ignoring blank values.
+func (p *Profile) TotalPages(row []Column) (int64, error) {
+	return sumPageColumns(row, p.PageCount)
+}
+
+// ReleasedPages adds up the PagesReleased headers of the row, ignoring blank values.
+func (p *Profile) ReleasedPages(row []Column) (int64, error) {
+	return sumPageColumns(row, p.PagesReleased)
+}
+
+func sumPageColumns(row []Column, headers []string) (int64, error) {
+	var totalPages int64
+	for _, column := range row {
+		for _, header := range headers {
+			value := strings.TrimSpace(column.Value)
+			if column.Header != header || len(value) == 0 {
+				continue
+			}
+			pg, err := strconv.Atoi(value)
+			if err != nil || pg < 0 {
+				return 0, fmt.Errorf("the %v of %q is not a page count", header, column.Value)
+			}
+			totalPages += int64(pg)
+		}
+	}
+	return totalPages, nil
+}
+
+// RequiresDate returns true when the profile rejects rows whose date field is blank or cannot be parsed.
+func (p *Profile) RequiresDate(field string) bool {
+	for _, required := range p.RequiredDates {
+		if required == field {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveURL replaces every {field} in the URLTemplate with the value of that field, returning a blank string when
+// the profile does not have a URLTemplate or when one of the fields in the template does not have a value.
+func (p *Profile) ResolveURL(values map[string]string) string {
+	if len(p.URLTemplate) == 0 {
+		return ""
+	}
+	var replacements []string
+	for field, value := range values {
+		replacements = append(replacements, "{"+field+"}", value)
+	}
+	resolved := strings.NewReplacer(replacements...).Replace(p.URLTemplate)
+	if strings.Contains(resolved, "{") {
+		return ""
+	}
+	return resolved
+}
+
+// Metadata returns the values of the fields that are not canonical fields so they can be stored with the record.
+func (p *Profile) Metadata(values map[string]string) map[string]string {
+	canonical := make(map[string]struct{}, len(sl_profile_canonical_fields))
+	for _, field := range sl_profile_canonical_fields {
+		canonical[field] = struct{}{}
+	}
+	metadata := make(map[string]string)
+	for field, value := range values {
+		if _, found := canonical[field]; !found {
+			metadata[field] = value
+		}
+	}
+	return metadata
+}
@@ -0,0 +1,108 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`os`
+	`path/filepath`
+	`testing`
+)
+
+func Test_loadProfile(t *testing.T) {
+	custom := filepath.Join(t.TempDir(), "custom.json")
+	err := os.WriteFile(custom, []byte(`{"fields": {"pdf_url": ["Link"], "box": ["Box Number"]}, "page_count": ["Pages"]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		profile  string
+		filename string
+		row      []Column
+		want     map[string]string
+		pages    int64
+		released int64
+		pagesErr bool
+		url      string
+	}{
+		{
+			name:     "jfk selected by filename",
+			filename: "jfk2023b.csv",
+			row:      []Column{{Header: "File Name", Value: "2023/104-10061-10328.pdf"}, {Header: "Record Num", Value: "104-10061-10328"}, {Header: "Num Pages", Value: "3"}, {Header: "Pages Released", Value: "2"}},
+			want:     map[string]string{"filename": "2023/104-10061-10328.pdf", "record_number": "104-10061-10328"},
+			pages:    3,
+			released: 2,
+			url:      "https://www.archives.gov/files/research/jfk/releases/2023/104-10061-10328.pdf",
+		},
+		{
+			name:     "jfk2021 selected over jfk",
+			filename: "jfk2021.xlsx",
+			row:      []Column{{Header: "Record Number", Value: "104-10004-10143"}, {Header: "File Title", Value: "DOCID-32206291.pdf"}, {Header: "Title", Value: "MEMO"}, {Header: "Original Document Pages", Value: "5"}, {Header: "Document Pages in PDF", Value: "4"}},
+			want:     map[string]string{"record_number": "104-10004-10143", "filename": "DOCID-32206291.pdf", "title": "MEMO"},
+			pages:    5,
+			released: 4,
+			url:      "https://www.archives.gov/files/research/jfk/releases/2021/DOCID-32206291.pdf",
+		},
+		{
+			name:     "default when nothing matches",
+			filename: "stargate.psv",
+			row:      []Column{{Header: "filename", Value: "a.pdf"}, {Header: "title", Value: ""}, {Header: "Title", Value: "Second"}, {Header: "page_count", Value: "x"}},
+			want:     map[string]string{"filename": "a.pdf", "title": "Second"},
+			pagesErr: true,
+		},
+		{
+			name:     "custom json file",
+			profile:  custom,
+			filename: "jfk.csv",
+			row:      []Column{{Header: "Link", Value: "https://example.com/a.pdf"}, {Header: "Box Number", Value: "7"}, {Header: "Pages", Value: "2"}, {Header: "Pages", Value: "1"}},
+			want:     map[string]string{"pdf_url": "https://example.com/a.pdf", "box": "7"},
+			pages:    3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, err := loadProfile(tt.profile, tt.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values := profile.Values(tt.row)
+			if len(values) != len(tt.want) {
+				t.Errorf("Values() = %v, want %v", values, tt.want)
+			}
+			for field, want := range tt.want {
+				if values[field] != want {
+					t.Errorf("Values()[%v] = %v, want %v", field, values[field], want)
+				}
+			}
+			if got, err := profile.TotalPages(tt.row); got != tt.pages || (err != nil) != tt.pagesErr {
+				t.Errorf("TotalPages() = %v, %v, want %v", got, err, tt.pages)
+			}
+			if got, _ := profile.ReleasedPages(tt.row); got != tt.released {
+				t.Errorf("ReleasedPages() = %v, want %v", got, tt.released)
+			}
+			if got := profile.ResolveURL(values); got != tt.url {
+				t.Errorf("ResolveURL() = %v, want %v", got, tt.url)
+			}
+		})
+	}
+
+	if _, err := loadProfile("missing", ""); err == nil {
+		t.Errorf("Expected an error when loading a profile that does not exist")
+	}
+}
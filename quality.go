@@ -0,0 +1,212 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`encoding/csv`
+	`encoding/json`
+	`fmt`
+	`log`
+	`os`
+	`path/filepath`
+	`sort`
+	`strconv`
+)
+
+const (
+	c_ocr_grade_good = "good"
+	c_ocr_grade_fair = "fair"
+	c_ocr_grade_poor = "poor"
+
+	// the Score of an OCRQuality needed for each grade, anything below fair is poor
+	c_ocr_score_good = 0.8
+	c_ocr_score_fair = 0.6
+)
+
+// OCRQuality grades the text of a page. The MeanConfidence is the average tesseract confidence (0-100) of the words on
+// the page and the DictionaryRatio is the share of its words found in the m_language_dictionary. The Score is the
+// average of both on a 0-1 scale, or the DictionaryRatio alone when the chosen text has no confidences, such as the
+// text layer or OCR text without a TSV.
+type OCRQuality struct {
+	Source          string  `json:"source"`
+	Words           int     `json:"words"`
+	MeanConfidence  float64 `json:"mean_confidence"`
+	DictionaryRatio float64 `json:"dictionary_ratio"`
+	Score           float64 `json:"score"`
+	Grade           string  `json:"grade"`
+}
+
+// PageQuality is a row of the ocr_quality.csv report.
+type PageQuality struct {
+	RecordIdentifier string
+	PageIdentifier   string
+	PageNumber       int
+	PDFPath          string
+	ImagePath        string
+	Quality          OCRQuality
+}
+
+// meanWordConfidence returns the average confidence of the words that tesseract recognized, skipping the words with
+// a negative confidence, which tesseract uses for words it did not score.
+func meanWordConfidence(words []OCRWord) (float64, int) {
+	total := 0.0
+	scored := 0
+	for _, word := range words {
+		if word.Confidence < 0 {
+			continue
+		}
+		total += word.Confidence
+		scored++
+	}
+	if scored == 0 {
+		return 0, 0
+	}
+	return total / float64(scored), scored
+}
+
+// gradeOcrScore turns the Score of an OCRQuality into its grade.
+func gradeOcrScore(score float64) string {
+	switch {
+	case score >= c_ocr_score_good:
+		return c_ocr_grade_good
+	case score >= c_ocr_score_fair:
+		return c_ocr_grade_fair
+	default:
+		return c_ocr_grade_poor
+	}
+}
+
+// gradeOcrQuality measures the OCRQuality of the best text of the page once its OCRWords are loaded.
+func gradeOcrQuality(ctx context.Context, pp PendingPage) PendingPage {
+	text, err := os.ReadFile(pp.BestTextPath())
+	if err != nil {
+		log.Printf("cannot grade the OCR of page %v because %v cannot be read due to error %v", pp.Identifier, pp.BestTextPath(), err)
+		return pp
+	}
+	if !waitForDictionary(ctx, fmt.Sprintf("grading the OCR of page %v", pp.Identifier)) {
+		return pp
+	}
+	quality := OCRQuality{
+		Source:          pp.TextSource,
+		DictionaryRatio: dictionaryRatio(string(text)),
+	}
+	if pp.TextSource != c_text_source_text_layer {
+		// the confidences only describe the text when tesseract produced the text that was chosen
+		quality.MeanConfidence, quality.Words = meanWordConfidence(pp.OCRWords)
+	}
+	if quality.Words > 0 {
+		quality.Score = (quality.MeanConfidence/100 + quality.DictionaryRatio) / 2
+	} else {
+		quality.Words = measureTextQuality(string(text)).Tokens
+		quality.Score = quality.DictionaryRatio
+	}
+	quality.Grade = gradeOcrScore(quality.Score)
+	pp.OCRQuality = &quality
+	log.Printf("graded the %v text of page %v as %v (score %.2f, mean confidence %.1f, dictionary ratio %.2f)", quality.Source, pp.Identifier, quality.Grade, quality.Score, quality.MeanConfidence, quality.DictionaryRatio)
+	return pp
+}
+
+// loadPageQualities reads the OCRQuality of every page manifest under the dataDir, including the pages that were
+// graded by earlier runs. Pages that were never graded are left out.
+func loadPageQualities(dataDir string) ([]PageQuality, error) {
+	manifestPaths, err := filepath.Glob(filepath.Join(dataDir, "*", "pages", "page.*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var pages []PageQuality
+	for _, manifestPath := range manifestPaths {
+		manifest, readErr := os.ReadFile(manifestPath)
+		if readErr != nil {
+			log.Printf("leaving %v out of the OCR quality report because it cannot be read due to error %v", manifestPath, readErr)
+			continue
+		}
+		var pp PendingPage
+		readErr = json.Unmarshal(manifest, &pp)
+		if readErr != nil {
+			log.Printf("leaving %v out of the OCR quality report because it cannot be parsed due to error %v", manifestPath, readErr)
+			continue
+		}
+		if pp.OCRQuality == nil {
+			continue
+		}
+		pages = append(pages, PageQuality{
+			RecordIdentifier: pp.RecordIdentifier,
+			PageIdentifier:   pp.Identifier,
+			PageNumber:       pp.PageNumber,
+			PDFPath:          pp.PDFPath,
+			ImagePath:        pp.JPEG.Light.Original,
+			Quality:          *pp.OCRQuality,
+		})
+	}
+	return pages, nil
+}
+
+// writeOcrQualityReport writes the limit pages with the lowest OCR scores under the dataDir into the ocr_quality.csv
+// report, worst first, so that volunteers know which pages need manual transcription the most. It returns how many
+// pages were written.
+func writeOcrQualityReport(dataDir string, path string, limit int) (int, error) {
+	pages, err := loadPageQualities(dataDir)
+	if err != nil {
+		return 0, err
+	}
+
+	sort.SliceStable(pages, func(i, j int) bool {
+		if pages[i].Quality.Score != pages[j].Quality.Score {
+			return pages[i].Quality.Score < pages[j].Quality.Score
+		}
+		if pages[i].RecordIdentifier != pages[j].RecordIdentifier {
+			return pages[i].RecordIdentifier < pages[j].RecordIdentifier
+		}
+		return pages[i].PageNumber < pages[j].PageNumber
+	})
+	if limit > 0 && len(pages) > limit {
+		pages = pages[:limit]
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	err = writer.Write([]string{"record_identifier", "page_identifier", "page_number", "grade", "score", "mean_confidence", "dictionary_ratio", "words", "source", "pdf_path", "image_path"})
+	if err != nil {
+		return 0, err
+	}
+	for _, page := range pages {
+		err = writer.Write([]string{
+			page.RecordIdentifier,
+			page.PageIdentifier,
+			strconv.Itoa(page.PageNumber),
+			page.Quality.Grade,
+			strconv.FormatFloat(page.Quality.Score, 'f', 2, 64),
+			strconv.FormatFloat(page.Quality.MeanConfidence, 'f', 1, 64),
+			strconv.FormatFloat(page.Quality.DictionaryRatio, 'f', 2, 64),
+			strconv.Itoa(page.Quality.Words),
+			page.Quality.Source,
+			page.PDFPath,
+			page.ImagePath,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	writer.Flush()
+	return len(pages), writer.Error()
+}
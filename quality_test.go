@@ -0,0 +1,130 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`encoding/csv`
+	`fmt`
+	`os`
+	`path/filepath`
+	`reflect`
+	`testing`
+)
+
+func Test_gradeOcrQuality(t *testing.T) {
+	useTestDictionary(t, "the", "memorandum", "was", "sent", "to", "director")
+	tests := []struct {
+		name       string
+		text       string
+		confidence []float64
+		skipped    bool
+		source     string
+		grade      string
+		score      float64
+	}{
+		{name: "clean scan", text: "The memorandum was sent to the director", confidence: []float64{96, 94, -1}, grade: c_ocr_grade_good, score: 0.975},
+		{name: "faded scan", text: "The memorandum was sent to the director", confidence: []float64{30, 40}, grade: c_ocr_grade_fair, score: 0.675},
+		{name: "unreadable scan", text: "Tbe rnernorandurn wos seut ta tbe dlrector", confidence: []float64{35, 20}, grade: c_ocr_grade_poor, score: 0.1375},
+		{name: "text layer", text: "The memorandum was sent to the director", skipped: true, grade: c_ocr_grade_good, score: 1},
+		{name: "text layer chosen over the OCR", text: "The memorandum was sent to the director", source: c_text_source_text_layer, confidence: []float64{20, 10}, grade: c_ocr_grade_good, score: 1},
+		{name: "OCR without a TSV", text: "The memorandum was sent to the director", grade: c_ocr_grade_good, score: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pp := PendingPage{
+				Identifier:  "page",
+				OCRTextPath: filepath.Join(t.TempDir(), "ocr.000001.txt"),
+				TextSource:  c_text_source_ocr,
+				OCRSkipped:  tt.skipped,
+			}
+			if tt.skipped {
+				pp.TextSource = c_text_source_text_layer
+			}
+			if len(tt.source) > 0 {
+				pp.TextSource = tt.source
+			}
+			for _, confidence := range tt.confidence {
+				pp.OCRWords = append(pp.OCRWords, OCRWord{Text: "word", Confidence: confidence})
+			}
+			if err := os.WriteFile(pp.OCRTextPath, []byte(tt.text), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			pp = gradeOcrQuality(context.Background(), pp)
+			if pp.OCRQuality == nil {
+				t.Fatal("gradeOcrQuality() did not grade the page")
+			}
+			if pp.OCRQuality.Grade != tt.grade || pp.OCRQuality.Score != tt.score {
+				t.Errorf("gradeOcrQuality() = %+v, want the grade %v with a score of %v", pp.OCRQuality, tt.grade, tt.score)
+			}
+		})
+	}
+}
+
+func Test_writeOcrQualityReport(t *testing.T) {
+	dir := t.TempDir()
+	pagesDir := filepath.Join(dir, "record", "pages")
+	if err := os.MkdirAll(pagesDir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	// the manifests on disk include the pages that earlier runs graded, which this run never saw
+	for i, score := range []float64{0.9, 0.2, 0.7, 0.4} {
+		pp := PendingPage{
+			Identifier:       fmt.Sprintf("page-%d", i+1),
+			RecordIdentifier: "record",
+			PageNumber:       i + 1,
+			ManifestPath:     filepath.Join(pagesDir, fmt.Sprintf("page.%06d.json", i+1)),
+			OCRQuality:       &OCRQuality{Score: score, Grade: gradeOcrScore(score)},
+		}
+		if err := WritePendingPageToJson(pp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a page without an OCRQuality is never reported
+	ungraded := PendingPage{Identifier: "ungraded", RecordIdentifier: "record", PageNumber: 5, ManifestPath: filepath.Join(pagesDir, "page.000005.json")}
+	if err := WritePendingPageToJson(ungraded); err != nil {
+		t.Fatal(err)
+	}
+
+	report := filepath.Join(dir, "ocr_quality.csv")
+	written, err := writeOcrQualityReport(dir, report, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != 3 {
+		t.Errorf("writeOcrQualityReport() = %d pages, want 3", written)
+	}
+	file, err := os.Open(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got [][]string
+	for _, record := range records[1:] {
+		got = append(got, []string{record[2], record[3], record[4]})
+	}
+	want := [][]string{{"2", c_ocr_grade_poor, "0.20"}, {"4", c_ocr_grade_poor, "0.40"}, {"3", c_ocr_grade_fair, "0.70"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the worst pages %v, but got %v", want, got)
+	}
+}
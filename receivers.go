@@ -39,6 +39,9 @@ func receiveImportedRow(ctx context.Context, ch <-chan interface{}) {
 				rd, err = validatePdf(ctx, rd)
 				if err != nil {
 					log.Printf("received error on validatePdf for rd.URL %v ; err = %v", rd.URL, err)
+					// extractPlainTextFromPdf and extractPagesFromPdf will not run for this record
+					wg_active_tasks.Done()
+					wg_active_tasks.Done()
 				} else {
 					log.Printf("validated the downloaded PDF %v from URL %v, sending rd into ch_ExtractText", filepath.Base(rd.PDFPath), rd.URL)
 					if ch_ExtractText.CanWrite() {
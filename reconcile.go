@@ -0,0 +1,137 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`encoding/csv`
+	`log`
+	`os`
+	`path/filepath`
+	`sort`
+	`strconv`
+	`strings`
+	`time`
+)
+
+const (
+	c_mismatch_missing_pages   = "missing_pages"   // the PDF has fewer pages than the metadata declared or released
+	c_mismatch_extra_pages     = "extra_pages"     // the PDF has more pages than the metadata declared or released
+	c_mismatch_partial_release = "partial_release" // the "Pages Released" differs from the "Num Pages"
+)
+
+// reconcilePages compares the declared and released page counts of the metadata with the actual pages of the PDF. A
+// count of 0 means that the metadata did not have it, which is never a mismatch. When pages were released, the PDF only
+// holds the released pages, so those are the ones that the actual pages are compared against.
+func reconcilePages(declared int64, released int64, actual int64) Reconciliation {
+	reconciliation := Reconciliation{
+		Declared:     declared,
+		Released:     released,
+		Actual:       actual,
+		ReconciledAt: time.Now().UTC(),
+	}
+	expected := declared
+	if released > 0 {
+		expected = released
+	}
+	if expected > 0 && actual < expected {
+		reconciliation.Mismatches = append(reconciliation.Mismatches, c_mismatch_missing_pages)
+	}
+	if expected > 0 && actual > expected {
+		reconciliation.Mismatches = append(reconciliation.Mismatches, c_mismatch_extra_pages)
+	}
+	if declared > 0 && released > 0 && released != declared {
+		reconciliation.Mismatches = append(reconciliation.Mismatches, c_mismatch_partial_release)
+	}
+	return reconciliation
+}
+
+// reconcileRecord records the Reconciliation of the record in its record.json once extractPagesFromPdf knows how many
+// pages the PDF has, which is where writeReconciliationReport finds it at the end of the run.
+func reconcileRecord(ctx context.Context, record ResultData, actual int) ResultData {
+	reconciliation := reconcilePages(record.TotalPages, record.ReleasedPages, int64(actual))
+	reconciliation.Collection = aggregateCollection(ctx, record).Name
+	record.Reconciliation = &reconciliation
+	sm_documents.Store(record.Identifier, record)
+	err := writeRecordJson(record)
+	if err != nil {
+		log.Printf("failed to record the reconciliation of %v in %v due to error %v", record.Identifier, record.RecordPath, err)
+	}
+	if len(reconciliation.Mismatches) > 0 {
+		log.Printf("record %v (URL %v) in collection %v declared %d pages (%d released) but its PDF has %d pages: %v", record.Identifier, record.URL, reconciliation.Collection, reconciliation.Declared, reconciliation.Released, reconciliation.Actual, strings.Join(reconciliation.Mismatches, ", "))
+	}
+	return record
+}
+
+// writeReconciliationReport writes every record under the dataDir whose record.json has a mismatch into the
+// reconciliation.csv report, grouped by collection. The records that earlier runs compiled are read from disk as well,
+// so a resumed run still reports the whole collection. It returns how many records were written.
+func writeReconciliationReport(dataDir string, path string) (int, error) {
+	recordPaths, err := filepath.Glob(filepath.Join(dataDir, "*", "record.json"))
+	if err != nil {
+		return 0, err
+	}
+	var records []ResultData
+	for _, recordPath := range recordPaths {
+		rd, readErr := ReadResultDataFromJson(recordPath)
+		if readErr != nil {
+			log.Printf("leaving %v out of the reconciliation report because it cannot be read due to error %v", recordPath, readErr)
+			continue
+		}
+		if rd.Reconciliation == nil || len(rd.Reconciliation.Mismatches) == 0 {
+			continue
+		}
+		if len(rd.Reconciliation.Collection) == 0 {
+			rd.Reconciliation.Collection = rd.Metadata["collection"]
+		}
+		records = append(records, rd)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Reconciliation.Collection != records[j].Reconciliation.Collection {
+			return records[i].Reconciliation.Collection < records[j].Reconciliation.Collection
+		}
+		return records[i].Identifier < records[j].Identifier
+	})
+
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	err = writer.Write([]string{"collection", "identifier", "url", "declared", "released", "actual", "mismatches"})
+	if err != nil {
+		return 0, err
+	}
+	for _, rd := range records {
+		err = writer.Write([]string{
+			rd.Reconciliation.Collection,
+			rd.Identifier,
+			rd.URL,
+			strconv.FormatInt(rd.Reconciliation.Declared, 10),
+			strconv.FormatInt(rd.Reconciliation.Released, 10),
+			strconv.FormatInt(rd.Reconciliation.Actual, 10),
+			strings.Join(rd.Reconciliation.Mismatches, ";"),
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	writer.Flush()
+	return len(records), writer.Error()
+}
@@ -0,0 +1,128 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`encoding/csv`
+	`os`
+	`path/filepath`
+	`reflect`
+	`testing`
+)
+
+func Test_reconcilePages(t *testing.T) {
+	tests := []struct {
+		name       string
+		declared   int64
+		released   int64
+		actual     int64
+		mismatches []string
+	}{
+		{name: "matches", declared: 3, actual: 3},
+		{name: "nothing declared", actual: 7},
+		{name: "missing pages", declared: 5, actual: 3, mismatches: []string{c_mismatch_missing_pages}},
+		{name: "extra pages", declared: 2, actual: 3, mismatches: []string{c_mismatch_extra_pages}},
+		{name: "all pages released", declared: 4, released: 4, actual: 4},
+		{name: "partial release", declared: 4, released: 2, actual: 2, mismatches: []string{c_mismatch_partial_release}},
+		{name: "partial release of a short PDF", declared: 4, released: 2, actual: 1, mismatches: []string{c_mismatch_missing_pages, c_mismatch_partial_release}},
+		{name: "partial release of a long PDF", declared: 4, released: 2, actual: 4, mismatches: []string{c_mismatch_extra_pages, c_mismatch_partial_release}},
+		{name: "released without a declared count", released: 3, actual: 2, mismatches: []string{c_mismatch_missing_pages}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := reconcilePages(tt.declared, tt.released, tt.actual)
+			if !reflect.DeepEqual(got.Mismatches, tt.mismatches) {
+				t.Errorf("reconcilePages() mismatches = %v, want %v", got.Mismatches, tt.mismatches)
+			}
+			if got.Declared != tt.declared || got.Released != tt.released || got.Actual != tt.actual {
+				t.Errorf("reconcilePages() = %+v, want %d declared, %d released and %d actual", got, tt.declared, tt.released, tt.actual)
+			}
+		})
+	}
+}
+
+func Test_reconcileRecord(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.WithValue(context.Background(), CtxKey("filename"), "jfk2023.csv")
+	newRecord := func(name string, declared int64, released int64) ResultData {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0750); err != nil {
+			t.Fatal(err)
+		}
+		return ResultData{
+			Identifier:    NewStableIdentifier(9, name),
+			URL:           "https://example.com/" + name + ".pdf",
+			DataDir:       filepath.Join(dir, name),
+			RecordPath:    filepath.Join(dir, name, "record.json"),
+			TotalPages:    declared,
+			ReleasedPages: released,
+			Metadata:      map[string]string{"collection": "JFK"},
+		}
+	}
+	matching := reconcileRecord(ctx, newRecord("matching", 3, 3), 3)
+	partial := reconcileRecord(ctx, newRecord("partial", 4, 2), 2)
+	short := reconcileRecord(ctx, newRecord("short", 4, 2), 1)
+
+	for _, rd := range []ResultData{matching, partial, short} {
+		saved, err := ReadResultDataFromJson(rd.RecordPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.Reconciliation == nil || !reflect.DeepEqual(saved.Reconciliation.Mismatches, rd.Reconciliation.Mismatches) {
+			t.Errorf("Expected %v to record the reconciliation %+v, but got %+v", rd.RecordPath, rd.Reconciliation, saved.Reconciliation)
+		}
+	}
+
+	// a record that an earlier run compiled is only on disk, and was reconciled before collections were recorded
+	earlier := newRecord("earlier", 2, 0)
+	earlier.Metadata["collection"] = "HSCA"
+	earlier.Reconciliation = &Reconciliation{Declared: 2, Actual: 1, Mismatches: []string{c_mismatch_missing_pages}}
+	if err := WriteResultDataToJson(earlier); err != nil {
+		t.Fatal(err)
+	}
+
+	report := filepath.Join(dir, "reconciliation.csv")
+	written, err := writeReconciliationReport(dir, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"collection", "identifier", "url", "declared", "released", "actual", "mismatches"},
+		{"HSCA", earlier.Identifier, earlier.URL, "2", "0", "1", c_mismatch_missing_pages},
+	}
+	jfk := [][]string{
+		{"JFK", partial.Identifier, partial.URL, "4", "2", "2", c_mismatch_partial_release},
+		{"JFK", short.Identifier, short.URL, "4", "2", "1", c_mismatch_missing_pages + ";" + c_mismatch_partial_release},
+	}
+	if jfk[0][1] > jfk[1][1] {
+		jfk[0], jfk[1] = jfk[1], jfk[0]
+	}
+	want = append(want, jfk...)
+	if written != 3 || !reflect.DeepEqual(records, want) {
+		t.Errorf("Expected the reconciliation report %v, but got %d records %v", want, written, records)
+	}
+}
@@ -28,69 +28,85 @@ import (
 	"time"
 )
 
-const jfk_pdf_download_prefix = "https://www.archives.gov/files/research/jfk/releases/"
-
-func processRecord(ctx context.Context, row []Column) error {
-	log.Printf("processRecord received for row %v", row)
-
-	loadedFile := fmt.Sprintf("%s", ctx.Value(CtxKey("filename")))
-
-	var totalPages int64 = 0
-	var filename, title, collection, pdf_url, source_url, comments, record_number, to_name, from_name, agency string
-	var creation_date, release_date time.Time
-
-	// header fields for different files
-	// stargate = checksum|filename|type|bytes|title|collection|document_number|release_decision|original_classification|page_count|creation_date|release_date|sequence_number|bogus_date|case_number|pdf_url|source_url
-	// jfk2023b = File Name,Record Num,NARA Release Date,Formerly Withheld,Agency,Doc Date,Doc Type,File Num,To Name,From Name,Title,Num Pages,Originator,Record Series,Review Date,Comments,Pages Released
-	// jfk2022 = File Name,Record Num,NARA Release Date,Formerly Withheld,Doc Date,Doc Type,File Num,To Name,From Name,Title,Num Pages,Originator,Record Series,Review Date,Comments,Pages Released
-	// jfk2021 = Record Number,File Title,NARA Release Date,Formerly Withheld,Document Date,Document Type,File Number,To,From,Title,Original Document Pages,Originator,Record Series,Review Date,Comments,Document Pages in PDF
-	// jfk2018 = File Name,Record Num,NARA Release Date,Formerly Withheld,Agency,Doc Date,Doc Type,File Num,To Name,From Name,Title,Num Pages,Originator,Record Series,Review Date,Comments,Pages Released
-
-	var dateErr error
-	for _, r := range row {
-		switch r.Header {
-		case "filename", "File Name":
-			filename = r.Value
-		case "title", "Title", "File Title":
-			title = r.Value
-		case "Comments":
-			comments = r.Value
-		case "To Name", "To":
-			to_name = r.Value
-		case "From Name", "From":
-			from_name = r.Value
-		case "collection", "Record Series":
-			collection = r.Value
-		case "pdf_url":
-			pdf_url = r.Value
-		case "document_number", "Record Num":
-			record_number = r.Value
-		case "Agency":
-			agency = r.Value
-		case "source_url":
-			source_url = r.Value
-		case "creation_date", "Doc Date", "Document Date":
-			creation_date, dateErr = parseDateString(r.Value)
-			if dateErr != nil {
-				log.Printf("failed to parse the release date %v due to error %v", r.Value, dateErr)
-			}
-		case "release_date", "NARA Release Date":
-			release_date, dateErr = parseDateString(r.Value)
-			if dateErr != nil {
-				log.Printf("failed to parse the release date %v due to error %v", r.Value, dateErr)
-			}
-		case "page_count", "Num Pages", "Original Document Pages":
-			pg, err := strconv.Atoi(r.Value)
-			if err == nil {
-				totalPages += int64(pg)
-			}
+// RecordPlan is everything that processRecord needs to know about a row before it downloads the PDF of the record.
+type RecordPlan struct {
+	URL         string
+	URLChecksum string
+	Checksum    string
+	LocalPath   string
+	RecordDir   string
+	PDFPath     string
+	TotalPages  int64
+	// ReleasedPages is the "Pages Released" of files like the jfk spreadsheets, which is 0 when it is not known
+	ReleasedPages int64
+	Metadata      map[string]string
+}
+
+// planRecord maps and validates the row using the profile in the ctx without touching the disk or the network. Rows
+// that fail validation return a RowRejection.
+func planRecord(ctx context.Context, row []Column) (RecordPlan, error) {
+	profile, profileErr := profileFromContext(ctx)
+	if profileErr != nil {
+		return RecordPlan{}, profileErr
+	}
+
+	// the profile maps the headers of the -file onto these fields, see profiles/default.yaml for the format
+	values := profile.Values(row)
+	totalPages, pagesErr := profile.TotalPages(row)
+	if pagesErr != nil {
+		return RecordPlan{}, RowRejection{Reason: c_reject_bad_page_count, Err: pagesErr}
+	}
+	releasedPages, releasedErr := profile.ReleasedPages(row)
+	if releasedErr != nil {
+		return RecordPlan{}, RowRejection{Reason: c_reject_bad_page_count, Err: releasedErr}
+	}
+	var (
+		filename      = values["filename"]
+		title         = values["title"]
+		collection    = values["collection"]
+		pdf_url       = values["pdf_url"]
+		source_url    = values["source_url"]
+		comments      = values["comments"]
+		record_number = values["record_number"]
+		to_name       = values["to_name"]
+		from_name     = values["from_name"]
+		agency        = values["agency"]
+	)
+	var local_path, sheet, row_number string
+	for _, column := range row {
+		switch column.Header {
+		case c_column_local_path:
+			local_path = column.Value
+		case c_column_sheet:
+			sheet = column.Value
+		case c_column_row_number:
+			row_number = column.Value
 		}
 	}
-	a_i_total_pages.Add(totalPages)
+	if len(filename) == 0 && len(local_path) > 0 {
+		filename = filepath.Base(local_path)
+	}
+	creation_date, dateErr := planDate(profile, values, "creation_date", rowNumber(row))
+	if dateErr != nil {
+		return RecordPlan{}, dateErr
+	}
+	release_date, dateErr := planDate(profile, values, "release_date", rowNumber(row))
+	if dateErr != nil {
+		return RecordPlan{}, dateErr
+	}
 
-	if !strings.HasPrefix(pdf_url, "http") && strings.Contains(loadedFile, "jfk") {
-		pdf_url = jfk_pdf_download_prefix + filename
-		log.Printf("pdf_url = %v", pdf_url)
+	if !isFetchableURL(pdf_url) && len(local_path) == 0 {
+		if resolved := profile.ResolveURL(values); len(resolved) > 0 {
+			pdf_url = resolved
+			log.Printf("pdf_url = %v", pdf_url)
+		}
+	}
+	if !isFetchableURL(pdf_url) && len(local_path) > 0 {
+		// files that were loaded from disk without a pdf_url in their sidecar are identified by their path
+		pdf_url = c_file_url_prefix + filepath.ToSlash(local_path)
+	}
+	if !isFetchableURL(pdf_url) && len(local_path) == 0 {
+		return RecordPlan{}, RowRejection{Reason: c_reject_missing_url, Err: fmt.Errorf("the pdf_url %q is not a URL and the %v profile could not build one", pdf_url, profile.Name)}
 	}
 
 	if !strings.HasPrefix(source_url, "http") {
@@ -103,40 +119,7 @@ func processRecord(ctx context.Context, row []Column) error {
 		}
 	}
 
-	pdf_url_checksum := Sha256(pdf_url)
-
-	identifier := NewIdentifier(6)
-
-	recordDir := filepath.Join(dir_data_directory, pdf_url_checksum)
-	err := os.MkdirAll(recordDir, 0750)
-	if err != nil {
-		return err
-	}
-
-	var (
-		q_file_pdf       = filepath.Join(recordDir, strings.ReplaceAll(filename, `/`, `_`))
-		q_file_ocr       = filepath.Join(recordDir, "ocr.txt")
-		q_file_extracted = filepath.Join(recordDir, "extracted.txt")
-		q_file_record    = filepath.Join(recordDir, "record.json")
-	)
-
-	_, downloadedPdfErr := os.Stat(q_file_pdf)
-	if os.IsNotExist(downloadedPdfErr) {
-		log.Printf("downloading URL %v to %v", pdf_url, q_file_pdf)
-		err = downloadFile(ctx, pdf_url, q_file_pdf)
-		if err != nil {
-			return err
-		}
-	}
-
-	pdfFile, pdfFileErr := os.Open(q_file_pdf)
-	if pdfFileErr != nil {
-		return pdfFileErr
-	}
-	checksum := FileSha512(pdfFile)
-	pdfFile.Close()
-
-	metadata := make(map[string]string)
+	metadata := profile.Metadata(values)
 	if len(title) > 0 {
 		metadata["title"] = title
 	}
@@ -164,23 +147,187 @@ func processRecord(ctx context.Context, row []Column) error {
 	if len(collection) > 0 {
 		metadata["collection"] = collection
 	}
+	if len(sheet) > 0 {
+		metadata["sheet"] = sheet
+	}
+	if len(row_number) > 0 {
+		metadata["row_number"] = row_number
+	}
+
+	pdf_url_checksum := Sha256(pdf_url)
+	recordDir := filepath.Join(dir_data_directory, pdf_url_checksum)
+	return RecordPlan{
+		URL:           pdf_url,
+		URLChecksum:   pdf_url_checksum,
+		Checksum:      values["checksum"],
+		LocalPath:     local_path,
+		RecordDir:     recordDir,
+		PDFPath:       filepath.Join(recordDir, strings.ReplaceAll(filename, `/`, `_`)),
+		TotalPages:    totalPages,
+		ReleasedPages: releasedPages,
+		Metadata:      metadata,
+	}, nil
+}
+
+// planDate parses the date field of the row. Dates that are unknown in the source, like the "00/00/0000" and
+// "06/00/1975" of the jfk spreadsheets, are left blank with a warning unless the profile lists the field in its
+// required_dates, which rejects the row instead.
+func planDate(profile *Profile, values map[string]string, field string, row string) (time.Time, error) {
+	value := values[field]
+	required := profile.RequiresDate(field)
+	if len(value) == 0 {
+		if required {
+			return time.Time{}, RowRejection{Reason: c_reject_unparsable_date, Err: fmt.Errorf("the %v is required but blank", field)}
+		}
+		return time.Time{}, nil
+	}
+	date, err := parseDateString(value, profile.DateFormats...)
+	if err == nil {
+		return date, nil
+	}
+	if required {
+		return time.Time{}, RowRejection{Reason: c_reject_unparsable_date, Err: fmt.Errorf("%v %v: %v", field, value, err)}
+	}
+	log.Printf("WARNING: leaving the %v of row %v blank because %q cannot be parsed due to error %v", field, row, value, err)
+	return time.Time{}, nil
+}
+
+func processRecord(ctx context.Context, row []Column) (err error) {
+	log.Printf("processRecord received row %v: %v", rowNumber(row), row)
+
+	plan, planErr := planRecord(ctx, row)
+	if planErr != nil {
+		return planErr
+	}
+
+	var (
+		q_file_pdf       = plan.PDFPath
+		q_file_ocr       = filepath.Join(plan.RecordDir, "ocr.txt")
+		q_file_extracted = filepath.Join(plan.RecordDir, "extracted.txt")
+		q_file_record    = filepath.Join(plan.RecordDir, "record.json")
+		q_file_download  = filepath.Join(plan.RecordDir, "download.json")
+	)
+
+	identifier := journalRecordIdentifier(plan.URLChecksum)
+	if _, inFlight := sm_records_in_flight.LoadOrStore(identifier, plan.URL); inFlight {
+		log.Printf("skipping row %v because record %v (URL %v) is already in the pipeline", rowNumber(row), identifier, plan.URL)
+		return nil
+	}
+	defer func() {
+		if err != nil {
+			// a later row with the same URL can try the record again
+			sm_records_in_flight.Delete(identifier)
+		}
+	}()
+	_, downloadedPdfErr := os.Stat(q_file_pdf)
+	if *flag_b_refresh && downloadedPdfErr == nil && len(plan.LocalPath) == 0 {
+		changed, refreshErr := refreshPdf(ctx, plan.URL, q_file_pdf, q_file_download, plan.Checksum)
+		if refreshErr != nil {
+			log.Printf("failed to refresh %v, keeping the PDF that was already downloaded due to error %v", plan.URL, refreshErr)
+		} else if changed {
+			log.Printf("the PDF of record %v changed, running it through the pipeline again", identifier)
+			aliasErr := resetRecordAliases(q_file_record)
+			if aliasErr != nil {
+				return aliasErr
+			}
+			journalResetRecord(identifier)
+			resetErr := resetRecordDir(plan.RecordDir, filepath.Base(q_file_pdf), filepath.Base(q_file_download), filepath.Base(q_file_record))
+			if resetErr != nil {
+				return resetErr
+			}
+		}
+	}
+
+	if journalRecordHasStage(identifier, c_journal_record_compiled) {
+		log.Printf("skipping record %v (URL %v) because a previous run already compiled it", identifier, plan.URL)
+		return nil
+	}
+
+	err = os.MkdirAll(plan.RecordDir, 0750)
+	if err != nil {
+		return err
+	}
+
+	if os.IsNotExist(downloadedPdfErr) && len(plan.LocalPath) > 0 {
+		log.Printf("copying %v to %v", plan.LocalPath, q_file_pdf)
+		err = copyFile(plan.LocalPath, q_file_pdf)
+		if err != nil {
+			return err
+		}
+	} else if os.IsNotExist(downloadedPdfErr) {
+		log.Printf("downloading URL %v to %v", plan.URL, q_file_pdf)
+		cache, downloadErr := downloadFile(ctx, plan.URL, q_file_pdf, plan.Checksum, DownloadCache{})
+		if downloadErr != nil {
+			class, _, _ := classifyDownloadError(downloadErr)
+			return RowRejection{Reason: c_reject_download + class, Err: downloadErr}
+		}
+		err = writeDownloadCache(q_file_download, cache)
+		if err != nil {
+			log.Printf("failed to write the download cache %v due to error %v", q_file_download, err)
+		}
+	}
+
+	pdfFile, pdfFileErr := os.Open(downloadedPdfPath(q_file_pdf))
+	if pdfFileErr != nil {
+		return pdfFileErr
+	}
+	checksum := FileSha512(pdfFile)
+	pdfFile.Close()
+
+	var history []PDFChecksum
+	var normalization *PDFNormalization
+	previous, previousErr := ReadResultDataFromJson(q_file_record)
+	if previousErr == nil {
+		history = previous.History
+		if len(previous.PDFChecksum) > 0 && previous.PDFChecksum != checksum {
+			history = append(history, PDFChecksum{Checksum: previous.PDFChecksum, ReplacedAt: time.Now().UTC()})
+		} else {
+			normalization = previous.Normalization
+		}
+	}
+
 	rd := ResultData{
 		Identifier:        identifier,
-		URL:               pdf_url,
-		DataDir:           recordDir,
-		TotalPages:        totalPages,
+		URL:               plan.URL,
+		DataDir:           plan.RecordDir,
+		TotalPages:        plan.TotalPages,
+		ReleasedPages:     plan.ReleasedPages,
 		PDFChecksum:       checksum,
 		PDFPath:           q_file_pdf,
 		OCRTextPath:       q_file_ocr,
 		ExtractedTextPath: q_file_extracted,
 		RecordPath:        q_file_record,
-		Metadata:          metadata,
+		Metadata:          plan.Metadata,
+		History:           history,
+		Normalization:     normalization,
 	}
-	err = WriteResultDataToJson(rd)
+	err = writeRecordJson(rd)
 	if err != nil {
 		return err
 	}
+	rd, duplicate, dedupErr := dedupRecord(rd)
+	if dedupErr != nil {
+		log.Printf("processing record %v on its own because it cannot be deduplicated due to error %v", identifier, dedupErr)
+	}
 	sm_documents.Store(identifier, rd)
+	if duplicate {
+		// the pages belong to the owner, but the alias still needs its own documents row and compiled stage
+		document := aliasDocument(ctx, rd)
+		wg_active_tasks.Add(1)
+		if !ch_CompiledDocument.CanWrite() {
+			wg_active_tasks.Done()
+			return nil
+		}
+		err = ch_CompiledDocument.Write(document)
+		if err != nil {
+			wg_active_tasks.Done()
+			log.Printf("cant write to the ch_CompiledDocument channel due to error %v", err)
+			return err
+		}
+		return nil
+	}
+	// only the records that go through the pipeline count, not the ones that were skipped or deduplicated above
+	a_i_total_pages.Add(plan.TotalPages)
 	log.Printf("sending URL %v (rd struct) into the ch_ImportedRow channel", rd.URL)
 	err = ch_ImportedRow.Write(rd)
 	if err != nil {
@@ -0,0 +1,56 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`errors`
+	`testing`
+	`time`
+)
+
+func Test_planDate(t *testing.T) {
+	lenient := &Profile{DateFormats: []string{"01/02/2006"}}
+	strict := &Profile{DateFormats: []string{"01/02/2006"}, RequiredDates: []string{"creation_date"}}
+	tests := []struct {
+		name     string
+		profile  *Profile
+		value    string
+		want     time.Time
+		rejected bool
+	}{
+		{name: "valid date", profile: lenient, value: "06/27/1978", want: time.Date(1978, time.June, 27, 0, 0, 0, 0, time.UTC)},
+		{name: "blank date", profile: lenient},
+		{name: "unknown date", profile: lenient, value: "00/00/0000"},
+		{name: "unknown day", profile: lenient, value: "06/00/1975"},
+		{name: "unknown required date", profile: strict, value: "00/00/0000", rejected: true},
+		{name: "blank required date", profile: strict, rejected: true},
+		{name: "valid required date", profile: strict, value: "06/27/1978", want: time.Date(1978, time.June, 27, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := planDate(tt.profile, map[string]string{"creation_date": tt.value}, "creation_date", "2")
+			var rejection RowRejection
+			if tt.rejected != errors.As(err, &rejection) || (tt.rejected && rejection.Reason != c_reject_unparsable_date) {
+				t.Fatalf("planDate() error = %v, want rejected %v", err, tt.rejected)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("planDate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
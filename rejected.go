@@ -0,0 +1,147 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`encoding/csv`
+	`encoding/json`
+	`fmt`
+	`log`
+	`os`
+	`strconv`
+)
+
+const (
+	c_reject_csv_parse          = "csv_parse_error"
+	c_reject_invalid_json       = "invalid_json"
+	c_reject_blank_first_column = "blank_first_column"
+	c_reject_missing_url        = "missing_url"
+	c_reject_unparsable_date    = "unparsable_date"
+	c_reject_bad_page_count     = "bad_page_count"
+	c_reject_processing         = "processing_error"
+	c_reject_download           = "download_" // followed by the class from classifyDownloadError
+)
+
+// RowRejection is returned by a CallbackFunc when a row fails validation so that ReceiveRows can record the reason.
+type RowRejection struct {
+	Reason string
+	Err    error
+}
+
+func (r RowRejection) Error() string {
+	return fmt.Sprintf("%v: %v", r.Reason, r.Err)
+}
+
+func (r RowRejection) Unwrap() error {
+	return r.Err
+}
+
+// RejectFunc records a row that will not be processed, see rejectRow.
+type RejectFunc func(filename string, rowNumber int, reason string, reasonErr error, raw string)
+
+// rejectorFromContext returns the RejectFunc that the loaders report their rejected rows to, which is rejectRow unless
+// the ctx carries another one, like the ignoreRejectedRow of the -locations-file.
+func rejectorFromContext(ctx context.Context) RejectFunc {
+	reject, ok := ctx.Value(CtxKey("reject")).(RejectFunc)
+	if !ok {
+		return rejectRow
+	}
+	return reject
+}
+
+// ignoreRejectedRow only logs the rows of reference files that are skipped, since they are not rows of the -file.
+func ignoreRejectedRow(filename string, rowNumber int, reason string, reasonErr error, raw string) {
+	log.Printf("skipped row %d of %v (%v) due to error %v with data %v", rowNumber, filename, reason, reasonErr, raw)
+}
+
+// loadRejected creates the rejected.csv report for this run, replacing the report of a previous run.
+func loadRejected(path string) error {
+	mu_rejected.Lock()
+	defer mu_rejected.Unlock()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(file)
+	err = writer.Write([]string{"file", "row_number", "reason", "error", "raw"})
+	if err != nil {
+		file.Close()
+		return err
+	}
+	writer.Flush()
+	file_rejected = file
+	csv_rejected = writer
+	return nil
+}
+
+func closeRejected() {
+	mu_rejected.Lock()
+	defer mu_rejected.Unlock()
+	if file_rejected == nil {
+		return
+	}
+	csv_rejected.Flush()
+	err := file_rejected.Close()
+	if err != nil {
+		log.Printf("failed to close the rejected rows report due to error %v", err)
+	}
+	file_rejected = nil
+	csv_rejected = nil
+}
+
+// rejectRow records a row of the input file that will not be processed. The raw value is the row as it was read.
+func rejectRow(filename string, rowNumber int, reason string, reasonErr error, raw string) {
+	log.Printf("rejected row %d of %v (%v) due to error %v with data %v", rowNumber, filename, reason, reasonErr, raw)
+	a_i_rejected_rows.Add(1)
+	if a_b_dry_run.Load() {
+		dryRunReject(filename, rowNumber, reason, reasonErr)
+	}
+
+	mu_rejected.Lock()
+	defer mu_rejected.Unlock()
+	if csv_rejected == nil {
+		return
+	}
+	var errorMessage string
+	if reasonErr != nil {
+		errorMessage = reasonErr.Error()
+	}
+	err := csv_rejected.Write([]string{filename, strconv.Itoa(rowNumber), reason, errorMessage, raw})
+	if err == nil {
+		csv_rejected.Flush()
+		err = csv_rejected.Error()
+	}
+	if err != nil {
+		log.Printf("failed to write row %d of %v to the rejected rows report due to error %v", rowNumber, filename, err)
+	}
+}
+
+// rawRow encodes the columns of a row as a JSON object for the rejected rows report.
+func rawRow(row []Column) string {
+	fields := make(map[string]string, len(row))
+	for _, column := range row {
+		fields[column.Header] = column.Value
+	}
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Sprintf("%v", row)
+	}
+	return string(raw)
+}
@@ -0,0 +1,125 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`encoding/csv`
+	`fmt`
+	`os`
+	`path/filepath`
+	`reflect`
+	`testing`
+)
+
+func Test_rejectRow(t *testing.T) {
+	dir := t.TempDir()
+	report := filepath.Join(dir, "rejected.csv")
+	if err := loadRejected(report); err != nil {
+		t.Fatal(err)
+	}
+	defer closeRejected()
+
+	input := filepath.Join(dir, "records.csv")
+	contents := "filename,pdf_url\na.pdf,https://example.com/a.pdf\nb.pdf,bad \"quote\"\n,https://example.com/c.pdf\nd.pdf,\ne.pdf,https://example.com/e.pdf\n"
+	if err := os.WriteFile(input, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var processed []string
+	err := loadCsv(context.Background(), input, func(ctx context.Context, row []Column) error {
+		values := make(map[string]string)
+		for _, column := range row {
+			values[column.Header] = column.Value
+		}
+		if len(values["pdf_url"]) == 0 {
+			return RowRejection{Reason: c_reject_missing_url, Err: fmt.Errorf("no pdf_url")}
+		}
+		processed = append(processed, values["filename"])
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeRejected()
+
+	if want := []string{"a.pdf", "e.pdf"}; !reflect.DeepEqual(processed, want) {
+		t.Errorf("Expected loadCsv to continue past the rejected rows and process %v, but processed %v", want, processed)
+	}
+
+	file, err := os.Open(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got [][]string
+	for _, record := range records[1:] {
+		got = append(got, []string{record[1], record[2]})
+	}
+	if want := "b.pdf,bad \"quote\""; len(records) < 2 || records[1][4] != want {
+		t.Errorf("Expected the malformed row to be reported as its source line %q, but got %v", want, records[1:])
+	}
+	want := [][]string{
+		{"3", c_reject_csv_parse},
+		{"4", c_reject_blank_first_column},
+		{"5", c_reject_missing_url},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the rejected rows %v, but got %v", want, got)
+	}
+}
+
+func Test_ignoreRejectedRow(t *testing.T) {
+	dir := t.TempDir()
+	report := filepath.Join(dir, "rejected.csv")
+	if err := loadRejected(report); err != nil {
+		t.Fatal(err)
+	}
+	defer closeRejected()
+
+	input := filepath.Join(dir, "locations.csv")
+	contents := "countryname,cityname\nRomania,Bucharest\n,Nowhere\nCuba,\"Havana\n"
+	if err := os.WriteFile(input, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	rejected := a_i_rejected_rows.Load()
+	ctx := context.WithValue(context.Background(), CtxKey("reject"), RejectFunc(ignoreRejectedRow))
+	err := loadCsv(ctx, input, func(ctx context.Context, row []Column) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeRejected()
+
+	if got := a_i_rejected_rows.Load(); got != rejected {
+		t.Errorf("Expected the skipped rows of a reference file not to be counted, but %d rows were rejected", got-rejected)
+	}
+	records, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "file,row_number,reason,error,raw\n"; string(records) != want {
+		t.Errorf("Expected only the header in the rejected rows report, but got %q", records)
+	}
+}
@@ -19,8 +19,385 @@ package main
 
 import (
 	`context`
+	`encoding/json`
+	`fmt`
+	`log`
+	`os`
+	`path/filepath`
+	`sort`
+	`strconv`
+	`strings`
 )
 
+const (
+	c_sql_dialect_postgres = "postgres"
+	c_sql_dialect_mysql    = "mysql"
+	c_sql_dialect_sqlite   = "sqlite"
+)
+
+// sqlDialect normalizes the -sql-dialect flag into one of the c_sql_dialect_* constants, returning an error when the
+// requested dialect is not supported.
+func sqlDialect(in string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(in)) {
+	case "postgres", "postgresql", "pg", "psql":
+		return c_sql_dialect_postgres, nil
+	case "mysql", "mariadb":
+		return c_sql_dialect_mysql, nil
+	case "sqlite", "sqlite3":
+		return c_sql_dialect_sqlite, nil
+	default:
+		return "", fmt.Errorf("unsupported sql dialect %v", in)
+	}
+}
+
 func compileDocumentSql(ctx context.Context, document Document) {
+	defer wg_active_tasks.Done()
+
+	dialect, dialectErr := sqlDialect(*flag_s_sql_dialect)
+	if dialectErr != nil {
+		log.Printf("cannot compile the sql for document %v due to error %v", document.Identifier, dialectErr)
+		return
+	}
+
+	once_sql_schema.Do(func() {
+		schemaPath := filepath.Join(dir_data_directory, fmt.Sprintf("schema.%v.sql", dialect))
+		err := os.WriteFile(schemaPath, []byte(schemaSql(dialect)), 0644)
+		if err != nil {
+			log.Printf("failed to write the sql schema %v due to error %v", schemaPath, err)
+			return
+		}
+		log.Printf("wrote the %v sql schema to %v", dialect, schemaPath)
+	})
+
+	ird, found := sm_documents.Load(document.Identifier)
+	if !found {
+		log.Printf("cannot compile the sql for document %v because it is missing from sm_documents", document.Identifier)
+		return
+	}
+	rd, ok := ird.(ResultData)
+	if !ok {
+		log.Printf("cannot typecast the sm_documents entry for %v as a .(ResultData)", document.Identifier)
+		return
+	}
+
+	pendingPages := make(map[string]PendingPage)
+	for _, page := range document.Pages {
+		ipp, found := sm_pages.Load(page.Identifier)
+		if !found {
+			continue
+		}
+		pp, ok := ipp.(PendingPage)
+		if !ok {
+			log.Printf("cannot typecast the sm_pages entry for %v as a .(PendingPage)", page.Identifier)
+			continue
+		}
+		pendingPages[page.Identifier] = pp
+	}
+
+	select {
+	case <-ctx.Done():
+		return
+	default:
+	}
+
+	sqlPath := filepath.Join(rd.DataDir, "record.sql")
+	err := os.WriteFile(sqlPath, []byte(documentSql(dialect, document, rd, pendingPages)), 0644)
+	if err != nil {
+		log.Printf("failed to write the sql for document %v to %v due to error %v", document.Identifier, sqlPath, err)
+		return
+	}
+	journalRecordStage(rd, c_journal_record_compiled)
+	log.Printf("Completed processing document %v and wrote its %v sql to %v", document.Identifier, dialect, sqlPath)
+}
+
+// schemaSql returns the CREATE TABLE statements that the record.sql files generated by documentSql insert into.
+func schemaSql(dialect string) string {
+	idType, textType, stringType, integerType, floatType, dateType := "VARCHAR(32)", "TEXT", "VARCHAR(255)", "BIGINT", "DOUBLE PRECISION", "DATE"
+	switch dialect {
+	case c_sql_dialect_mysql:
+		textType, floatType = "LONGTEXT", "DOUBLE"
+	case c_sql_dialect_sqlite:
+		idType, stringType, integerType, floatType, dateType = "TEXT", "TEXT", "INTEGER", "REAL", "TEXT"
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("-- Project Apario schema (%v)\n\n", dialect))
+	sb.WriteString(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS collections (
+    identifier %[1]v NOT NULL,
+    name %[3]v NOT NULL,
+    PRIMARY KEY (identifier)
+);
+
+CREATE TABLE IF NOT EXISTS documents (
+    identifier %[1]v NOT NULL,
+    url %[2]v NOT NULL,
+    pdf_checksum %[3]v,
+    total_pages %[4]v NOT NULL DEFAULT 0,
+    cover_page_identifier %[1]v,
+    collection_identifier %[1]v,
+    metadata %[2]v,
+    PRIMARY KEY (identifier)
+);
+
+CREATE TABLE IF NOT EXISTS pages (
+    identifier %[1]v NOT NULL,
+    document_identifier %[1]v NOT NULL,
+    page_number %[4]v NOT NULL,
+    language %[3]v,
+    full_text %[2]v,
+    gematria_jewish %[4]v NOT NULL DEFAULT 0,
+    gematria_english %[4]v NOT NULL DEFAULT 0,
+    gematria_simple %[4]v NOT NULL DEFAULT 0,
+    metadata %[2]v,
+    PRIMARY KEY (identifier)
+);
+
+CREATE TABLE IF NOT EXISTS page_words (
+    page_identifier %[1]v NOT NULL,
+    language %[3]v NOT NULL,
+    word %[3]v NOT NULL,
+    quantity %[4]v NOT NULL DEFAULT 0,
+    gematria_jewish %[4]v NOT NULL DEFAULT 0,
+    gematria_english %[4]v NOT NULL DEFAULT 0,
+    gematria_simple %[4]v NOT NULL DEFAULT 0,
+    PRIMARY KEY (page_identifier, language, word)
+);
+
+CREATE TABLE IF NOT EXISTS page_cryptonyms (
+    page_identifier %[1]v NOT NULL,
+    cryptonym %[3]v NOT NULL,
+    PRIMARY KEY (page_identifier, cryptonym)
+);
+
+CREATE TABLE IF NOT EXISTS page_dates (
+    page_identifier %[1]v NOT NULL,
+    occurred_on %[6]v NOT NULL,
+    PRIMARY KEY (page_identifier, occurred_on)
+);
+
+CREATE TABLE IF NOT EXISTS page_locations (
+    page_identifier %[1]v NOT NULL,
+    kind %[3]v NOT NULL,
+    continent %[3]v,
+    country %[3]v NOT NULL,
+    country_code %[3]v NOT NULL,
+    state %[3]v NOT NULL,
+    city %[3]v NOT NULL,
+    latitude %[5]v,
+    longitude %[5]v,
+    quantity %[4]v NOT NULL DEFAULT 0,
+    PRIMARY KEY (page_identifier, kind, country_code, state, city)
+);
+`, idType, textType, stringType, integerType, floatType, dateType))
+	return sb.String()
+}
+
+// documentSql returns an idempotent script that upserts the document, its collection and its pages, and replaces the
+// words, cryptonyms, dates and geography of every page that has a PendingPage in pendingPages (keyed by page identifier).
+func documentSql(dialect string, document Document, rd ResultData, pendingPages map[string]PendingPage) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("-- Project Apario document %v (%v)\n", document.Identifier, document.URL))
+	if dialect == c_sql_dialect_mysql {
+		sb.WriteString("START TRANSACTION;\n")
+	} else {
+		sb.WriteString("BEGIN;\n")
+	}
+
+	if len(document.Collection.Identifier) > 0 {
+		sb.WriteString(sqlUpsert(dialect, "collections",
+			[]string{"identifier", "name"},
+			[]string{sqlQuote(dialect, document.Collection.Identifier), sqlQuote(dialect, document.Collection.Name)},
+			1))
+	}
+
+	var collectionIdentifier = "NULL"
+	if len(document.Collection.Identifier) > 0 {
+		collectionIdentifier = sqlQuote(dialect, document.Collection.Identifier)
+	}
+	var coverPageIdentifier = "NULL"
+	if len(document.CoverPageIdentifier) > 0 {
+		coverPageIdentifier = sqlQuote(dialect, document.CoverPageIdentifier)
+	}
+	var metadata = rd.Metadata
+	if rd.AliasOf != nil {
+		// the pages of an alias are stored under the document that owns its PDF
+		metadata = make(map[string]string, len(rd.Metadata)+1)
+		for key, value := range rd.Metadata {
+			metadata[key] = value
+		}
+		metadata["alias_of"] = rd.AliasOf.Identifier
+	}
+	sb.WriteString(sqlUpsert(dialect, "documents",
+		[]string{"identifier", "url", "pdf_checksum", "total_pages", "cover_page_identifier", "collection_identifier", "metadata"},
+		[]string{
+			sqlQuote(dialect, document.Identifier),
+			sqlQuote(dialect, document.URL),
+			sqlQuote(dialect, rd.PDFChecksum),
+			strconv.FormatInt(document.TotalPages, 10),
+			coverPageIdentifier,
+			collectionIdentifier,
+			sqlJson(dialect, metadata),
+		},
+		1))
+
+	pageNumbers := make([]int64, 0, len(document.Pages))
+	for pageNumber := range document.Pages {
+		pageNumbers = append(pageNumbers, pageNumber)
+	}
+	sort.Slice(pageNumbers, func(i, j int) bool { return pageNumbers[i] < pageNumbers[j] })
+
+	for _, pageNumber := range pageNumbers {
+		page := document.Pages[pageNumber]
+		pp, hasPendingPage := pendingPages[page.Identifier]
+
+		var language = "NULL"
+		if hasPendingPage && len(pp.Language) > 0 {
+			language = sqlQuote(dialect, pp.Language)
+		}
+		sb.WriteString(sqlUpsert(dialect, "pages",
+			[]string{"identifier", "document_identifier", "page_number", "language", "full_text", "gematria_jewish", "gematria_english", "gematria_simple", "metadata"},
+			[]string{
+				sqlQuote(dialect, page.Identifier),
+				sqlQuote(dialect, document.Identifier),
+				strconv.FormatInt(page.PageNumber, 10),
+				language,
+				sqlQuote(dialect, page.FullText),
+				strconv.FormatUint(uint64(page.FullTextGematria.Jewish), 10),
+				strconv.FormatUint(uint64(page.FullTextGematria.English), 10),
+				strconv.FormatUint(uint64(page.FullTextGematria.Simple), 10),
+				sqlJson(dialect, page.Metadata),
+			},
+			1))
+
+		if !hasPendingPage {
+			continue
+		}
+
+		pageIdentifier := sqlQuote(dialect, page.Identifier)
+		for _, table := range []string{"page_words", "page_cryptonyms", "page_dates", "page_locations"} {
+			sb.WriteString(fmt.Sprintf("DELETE FROM %v WHERE page_identifier = %v;\n", table, pageIdentifier))
+		}
+
+		for _, wr := range pp.Words {
+			word := wr.Word
+			if len(word) == 0 {
+				word = wr.Gematria.Word
+			}
+			if len(word) == 0 {
+				continue
+			}
+			wordLanguage := wr.Language
+			if len(wordLanguage) == 0 {
+				wordLanguage = pp.Language
+			}
+			sb.WriteString(sqlUpsert(dialect, "page_words",
+				[]string{"page_identifier", "language", "word", "quantity", "gematria_jewish", "gematria_english", "gematria_simple"},
+				[]string{
+					pageIdentifier,
+					sqlQuote(dialect, wordLanguage),
+					sqlQuote(dialect, word),
+					strconv.Itoa(wr.Quantity),
+					strconv.FormatUint(uint64(wr.Gematria.Score.Jewish), 10),
+					strconv.FormatUint(uint64(wr.Gematria.Score.English), 10),
+					strconv.FormatUint(uint64(wr.Gematria.Score.Simple), 10),
+				},
+				3))
+		}
+
+		for _, cryptonym := range pp.Cryptonyms {
+			sb.WriteString(sqlUpsert(dialect, "page_cryptonyms",
+				[]string{"page_identifier", "cryptonym"},
+				[]string{pageIdentifier, sqlQuote(dialect, cryptonym)},
+				2))
+		}
+
+		for _, date := range pp.Dates {
+			sb.WriteString(sqlUpsert(dialect, "page_dates",
+				[]string{"page_identifier", "occurred_on"},
+				[]string{pageIdentifier, sqlQuote(dialect, date.Format("2006-01-02"))},
+				2))
+		}
+
+		locations := map[string][]CountableLocation{
+			"country": pp.Geography.Countries,
+			"state":   pp.Geography.States,
+			"city":    pp.Geography.Cities,
+		}
+		for _, kind := range []string{"country", "state", "city"} {
+			for _, cl := range locations[kind] {
+				if cl.Location == nil {
+					continue
+				}
+				sb.WriteString(sqlUpsert(dialect, "page_locations",
+					[]string{"page_identifier", "kind", "country_code", "state", "city", "continent", "country", "latitude", "longitude", "quantity"},
+					[]string{
+						pageIdentifier,
+						sqlQuote(dialect, kind),
+						sqlQuote(dialect, cl.Location.CountryCode),
+						sqlQuote(dialect, cl.Location.State),
+						sqlQuote(dialect, cl.Location.City),
+						sqlQuote(dialect, cl.Location.Continent),
+						sqlQuote(dialect, cl.Location.Country),
+						strconv.FormatFloat(cl.Location.Latitude, 'f', -1, 64),
+						strconv.FormatFloat(cl.Location.Longitude, 'f', -1, 64),
+						strconv.Itoa(cl.Quantity),
+					},
+					5))
+			}
+		}
+	}
+
+	sb.WriteString("COMMIT;\n")
+	return sb.String()
+}
+
+// sqlUpsert builds a single INSERT statement for the table that updates the existing row when the first keys columns
+// collide with an existing primary key. When every column is part of the key, the conflicting insert is ignored.
+func sqlUpsert(dialect string, table string, columns []string, values []string, keys int) string {
+	var updates []string
+	for _, column := range columns[keys:] {
+		switch dialect {
+		case c_sql_dialect_mysql:
+			updates = append(updates, fmt.Sprintf("%v = VALUES(%v)", column, column))
+		default:
+			updates = append(updates, fmt.Sprintf("%v = excluded.%v", column, column))
+		}
+	}
+
+	insert := fmt.Sprintf("INSERT INTO %v (%v) VALUES (%v)", table, strings.Join(columns, ", "), strings.Join(values, ", "))
+	switch dialect {
+	case c_sql_dialect_mysql:
+		if len(updates) == 0 {
+			return "INSERT IGNORE" + strings.TrimPrefix(insert, "INSERT") + ";\n"
+		}
+		return fmt.Sprintf("%v ON DUPLICATE KEY UPDATE %v;\n", insert, strings.Join(updates, ", "))
+	default:
+		conflict := strings.Join(columns[:keys], ", ")
+		if len(updates) == 0 {
+			return fmt.Sprintf("%v ON CONFLICT (%v) DO NOTHING;\n", insert, conflict)
+		}
+		return fmt.Sprintf("%v ON CONFLICT (%v) DO UPDATE SET %v;\n", insert, conflict, strings.Join(updates, ", "))
+	}
+}
+
+// sqlQuote returns in as a single quoted string literal that is safe to embed in a statement for the dialect.
+func sqlQuote(dialect string, in string) string {
+	in = strings.ReplaceAll(in, "\x00", "")
+	if dialect == c_sql_dialect_mysql {
+		in = strings.ReplaceAll(in, `\`, `\\`)
+	}
+	return "'" + strings.ReplaceAll(in, "'", "''") + "'"
+}
 
+// sqlJson returns the metadata encoded as a quoted JSON string literal, or NULL when there is no metadata.
+func sqlJson(dialect string, metadata map[string]string) string {
+	if len(metadata) == 0 {
+		return "NULL"
+	}
+	bytes, err := json.Marshal(metadata)
+	if err != nil {
+		log.Printf("failed to encode the metadata %v as json due to error %v", metadata, err)
+		return "NULL"
+	}
+	return sqlQuote(dialect, string(bytes))
 }
@@ -0,0 +1,130 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`strings`
+	`testing`
+	`time`
+)
+
+func Test_sqlUpsert(t *testing.T) {
+	testCases := []struct {
+		name     string
+		dialect  string
+		keys     int
+		expected string
+	}{
+		{
+			name:     "postgres update",
+			dialect:  c_sql_dialect_postgres,
+			keys:     1,
+			expected: "INSERT INTO pages (identifier, full_text) VALUES ('A', 'it''s') ON CONFLICT (identifier) DO UPDATE SET full_text = excluded.full_text;\n",
+		},
+		{
+			name:     "sqlite ignore",
+			dialect:  c_sql_dialect_sqlite,
+			keys:     2,
+			expected: "INSERT INTO pages (identifier, full_text) VALUES ('A', 'it''s') ON CONFLICT (identifier, full_text) DO NOTHING;\n",
+		},
+		{
+			name:     "mysql update",
+			dialect:  c_sql_dialect_mysql,
+			keys:     1,
+			expected: "INSERT INTO pages (identifier, full_text) VALUES ('A', 'it''s') ON DUPLICATE KEY UPDATE full_text = VALUES(full_text);\n",
+		},
+		{
+			name:     "mysql ignore",
+			dialect:  c_sql_dialect_mysql,
+			keys:     2,
+			expected: "INSERT IGNORE INTO pages (identifier, full_text) VALUES ('A', 'it''s');\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := sqlUpsert(tc.dialect, "pages", []string{"identifier", "full_text"}, []string{sqlQuote(tc.dialect, "A"), sqlQuote(tc.dialect, "it's")}, tc.keys)
+			if result != tc.expected {
+				t.Errorf("Expected %v, but got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func Test_documentSql(t *testing.T) {
+	document := Document{
+		Identifier: "2023ABCDEF",
+		URL:        "https://www.archives.gov/files/research/jfk/releases/2023/104-10061-10328.pdf",
+		Pages: map[int64]Page{
+			1: {Identifier: "2023ABCDEFGHI", DocumentIdentifier: "2023ABCDEF", PageNumber: 1, FullText: `O'BRIEN \ AMLASH`},
+		},
+		TotalPages:          1,
+		CoverPageIdentifier: "2023ABCDEFGHI",
+		Collection:          Collection{Identifier: "JFK", Name: "JFK"},
+	}
+	pendingPages := map[string]PendingPage{
+		"2023ABCDEFGHI": {
+			Identifier: "2023ABCDEFGHI",
+			Cryptonyms: []string{"AMLASH"},
+			Dates:      []time.Time{time.Date(1963, time.November, 22, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	for _, dialect := range []string{c_sql_dialect_postgres, c_sql_dialect_mysql, c_sql_dialect_sqlite} {
+		t.Run(dialect, func(t *testing.T) {
+			result := documentSql(dialect, document, ResultData{}, pendingPages)
+			for _, expected := range []string{
+				"INSERT INTO collections (identifier, name) VALUES ('JFK', 'JFK')",
+				"INSERT INTO documents (identifier, url, pdf_checksum, total_pages, cover_page_identifier, collection_identifier, metadata) VALUES ('2023ABCDEF'",
+				"DELETE FROM page_cryptonyms WHERE page_identifier = '2023ABCDEFGHI';",
+				"VALUES ('2023ABCDEFGHI', 'AMLASH')",
+				"VALUES ('2023ABCDEFGHI', '1963-11-22')",
+				"COMMIT;",
+			} {
+				if !strings.Contains(result, expected) {
+					t.Errorf("Expected %v to contain %v", result, expected)
+				}
+			}
+			if dialect == c_sql_dialect_mysql && !strings.Contains(result, `'O''BRIEN \\ AMLASH'`) {
+				t.Errorf("Expected the mysql backslash to be escaped in %v", result)
+			}
+			if dialect != c_sql_dialect_mysql && !strings.Contains(result, `'O''BRIEN \ AMLASH'`) {
+				t.Errorf("Expected the backslash to be preserved in %v", result)
+			}
+		})
+	}
+}
+
+func Test_documentSqlAlias(t *testing.T) {
+	document := Document{Identifier: "2022ALIASX", URL: "https://example.com/2022/a.pdf", Pages: map[int64]Page{}, TotalPages: 2, CoverPageIdentifier: "2017OWNERXPAGE"}
+	rd := ResultData{
+		Identifier: "2022ALIASX",
+		Metadata:   map[string]string{"title": "AMLASH"},
+		AliasOf:    &RecordAlias{Identifier: "2017OWNERX"},
+	}
+	result := documentSql(c_sql_dialect_postgres, document, rd, nil)
+	if !strings.Contains(result, `"alias_of":"2017OWNERX"`) || !strings.Contains(result, "'2017OWNERXPAGE'") {
+		t.Errorf("Expected the alias to point at the owner and its cover page in %v", result)
+	}
+	if strings.Contains(result, "INSERT INTO pages") {
+		t.Errorf("Expected the alias not to insert the pages of the owner in %v", result)
+	}
+	if _, found := rd.Metadata["alias_of"]; found {
+		t.Errorf("Expected the metadata of the record to be left alone, but got %v", rd.Metadata)
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`fmt`
+	`log`
+	`os`
+	`strings`
+	`time`
+	`unicode`
+)
+
+const (
+	c_text_source_ocr        = "ocr"
+	c_text_source_text_layer = "text_layer"
+
+	// c_text_min_letters is how many letters a text needs before its quality is measured, which keeps the stray
+	// characters that pdftotext finds on scanned pages from beating the OCR
+	c_text_min_letters = 20
+)
+
+// TextQuality measures how much of a text reads like words. The Score is the share of the Tokens that are Words.
+type TextQuality struct {
+	Tokens int     `json:"tokens"`
+	Words  int     `json:"words"`
+	Score  float64 `json:"score"`
+}
+
+// BestTextPath returns the text that the analyzers read for the page, which is the OCR text until chooseBestText
+// picked a TextPath.
+func (pp PendingPage) BestTextPath() string {
+	if len(pp.TextPath) > 0 {
+		return pp.TextPath
+	}
+	return pp.OCRTextPath
+}
+
+// measureTextQuality counts the tokens of the text that look like words: at least two letters, no digits or symbols
+// inside of them and at least one vowel, which OCR noise such as "lll" or "W1t" does not have.
+func measureTextQuality(text string) TextQuality {
+	var quality TextQuality
+	letters := 0
+	for _, token := range strings.Fields(text) {
+		quality.Tokens++
+		word := strings.TrimFunc(token, func(r rune) bool {
+			return unicode.IsPunct(r) || unicode.IsSymbol(r)
+		})
+		if isWordLike(word) {
+			quality.Words++
+		}
+		for _, r := range word {
+			if unicode.IsLetter(r) {
+				letters++
+			}
+		}
+	}
+	if letters >= c_text_min_letters && quality.Tokens > 0 {
+		quality.Score = float64(quality.Words) / float64(quality.Tokens)
+	}
+	return quality
+}
+
+func isWordLike(word string) bool {
+	if len([]rune(word)) < 2 {
+		return false
+	}
+	vowel := false
+	for _, r := range word {
+		if r == '\'' || r == '-' {
+			continue
+		}
+		if !unicode.IsLetter(r) {
+			return false
+		}
+		if strings.ContainsRune("aeiouyAEIOUY", r) {
+			vowel = true
+		}
+	}
+	return vowel
+}
+
+// dictionaryRatio returns the share of the words of the text that are found in any of the m_language_dictionary. Tokens
+// without letters, such as page numbers, are not counted. The dictionary must be loaded before this is called.
+func dictionaryRatio(text string) float64 {
+	counted := 0
+	found := 0
+	for _, token := range strings.Fields(text) {
+		word := strings.ToLower(strings.TrimFunc(token, func(r rune) bool {
+			return !unicode.IsLetter(r)
+		}))
+		if len(word) == 0 {
+			continue
+		}
+		counted++
+		for _, dictionary := range m_language_dictionary {
+			if _, ok := dictionary[word]; ok {
+				found++
+				break
+			}
+		}
+	}
+	if counted == 0 {
+		return 0
+	}
+	return float64(found) / float64(counted)
+}
+
+// waitForDictionary blocks until populateDictionary finished loading the m_language_dictionary, returning false when
+// the ctx is done first.
+func waitForDictionary(ctx context.Context, purpose string) bool {
+	for {
+		if a_b_dictionary_loaded.Load() {
+			return true
+		}
+		select {
+		case <-time.After(9 * time.Second):
+			log.Printf("waiting for word dictionary to finish loading before %v", purpose)
+			continue
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// textLayerSkipsOcr decides whether the text layer of the page is reliable enough to skip tesseract. The text layer
+// needs at least -skip-ocr-chars characters and a -skip-ocr-dictionary share of dictionary words, and nothing is
+// skipped when -force-ocr is set. The reason is kept in the manifest of the page either way.
+func textLayerSkipsOcr(ctx context.Context, pp PendingPage) (bool, string) {
+	if *flag_b_force_ocr {
+		return false, "-force-ocr is set"
+	}
+	text, err := os.ReadFile(pp.TextLayerPath)
+	if err != nil {
+		return false, "the page does not have a text layer"
+	}
+	characters := 0
+	for _, r := range string(text) {
+		if !unicode.IsSpace(r) {
+			characters++
+		}
+	}
+	if characters < *flag_i_skip_ocr_chars {
+		return false, fmt.Sprintf("the text layer has %d characters, fewer than the %d of -skip-ocr-chars", characters, *flag_i_skip_ocr_chars)
+	}
+
+	if !waitForDictionary(ctx, fmt.Sprintf("deciding whether page %v needs OCR", pp.Identifier)) {
+		return false, ctx.Err().Error()
+	}
+	ratio := dictionaryRatio(string(text))
+	if ratio < *flag_f_skip_ocr_dictionary {
+		return false, fmt.Sprintf("%.2f of the words in the text layer are in the dictionary, less than the %.2f of -skip-ocr-dictionary", ratio, *flag_f_skip_ocr_dictionary)
+	}
+	return true, fmt.Sprintf("the text layer has %d characters and %.2f of its words are in the dictionary", characters, ratio)
+}
+
+// extractPageTextLayer saves the text that is embedded in the PDF of the page to its TextLayerPath.
+func extractPageTextLayer(ctx context.Context, pp PendingPage) error {
+	if len(pp.TextLayerPath) == 0 {
+		return fmt.Errorf("page %v does not have a text layer path", pp.Identifier)
+	}
+	if _, err := os.Stat(pp.TextLayerPath); err == nil {
+		return nil
+	}
+	/*
+		pdftotext -enc UTF-8 REPLACE_WITH_PAGE_PDF_PATH REPLACE_WITH_TEXT_OUTPUT_FILE_PATH
+	*/
+	cmd := ToolCommand{Name: "pdftotext", Args: []string{"-enc", "UTF-8", pp.PDFPath, pp.TextLayerPath}, Semaphore: sem_pdftotext}
+	_, err := runTool(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("failed to execute `%v` due to error: %v", cmd, err)
+	}
+	return nil
+}
+
+// chooseBestText measures the OCR text and the text layer of the page and points the TextPath at the better one. The
+// text layer wins ties because it is exactly what the PDF contains, but a page without enough letters in either text
+// keeps the OCR. A page whose OCR was skipped always uses its text layer.
+func chooseBestText(pp PendingPage) PendingPage {
+	pp.TextQuality = make(map[string]TextQuality)
+	sources := map[string]string{
+		c_text_source_ocr:        pp.OCRTextPath,
+		c_text_source_text_layer: pp.TextLayerPath,
+	}
+	for source, path := range sources {
+		if len(path) == 0 {
+			continue
+		}
+		text, err := os.ReadFile(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("failed to read the %v text of page %v from %v due to error %v", source, pp.Identifier, path, err)
+			}
+			continue
+		}
+		pp.TextQuality[source] = measureTextQuality(string(text))
+	}
+
+	pp.TextSource = c_text_source_ocr
+	pp.TextPath = pp.OCRTextPath
+	ocr := pp.TextQuality[c_text_source_ocr]
+	layer, found := pp.TextQuality[c_text_source_text_layer]
+	if found && (pp.OCRSkipped || layer.Score > 0 && layer.Score >= ocr.Score) {
+		pp.TextSource = c_text_source_text_layer
+		pp.TextPath = pp.TextLayerPath
+	}
+	log.Printf("using the %v text of page %v (OCR score %.2f, text layer score %.2f)", pp.TextSource, pp.Identifier, ocr.Score, layer.Score)
+	return pp
+}
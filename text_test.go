@@ -0,0 +1,194 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`encoding/json`
+	`os`
+	`path/filepath`
+	`strings`
+	`testing`
+)
+
+func Test_measureTextQuality(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		tokens int
+		words  int
+		score  float64
+	}{
+		{name: "clean text", text: "The memorandum was sent to the director.", tokens: 7, words: 7, score: 1},
+		{name: "ocr noise", text: "Th3 m3m0randum lll was s3nt t0 the dir3ctor , ,", tokens: 10, words: 2, score: 0.2},
+		{name: "too few letters", text: "Page 1 of 3", tokens: 4, words: 2},
+		{name: "empty", text: " \n "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := measureTextQuality(tt.text)
+			if got.Tokens != tt.tokens || got.Words != tt.words || got.Score != tt.score {
+				t.Errorf("measureTextQuality() = %+v, want %d tokens, %d words and a score of %v", got, tt.tokens, tt.words, tt.score)
+			}
+		})
+	}
+}
+
+func Test_chooseBestText(t *testing.T) {
+	const clean = "The memorandum was sent to the director of the agency."
+	const noise = "Th3 m3m0randum lll was s3nt t0 the dir3ctor 0f th3 ag3ncy"
+	tests := []struct {
+		name   string
+		ocr    string
+		layer  string // blank when the PDF of the page does not have a text layer
+		source string
+	}{
+		{name: "scanned page", ocr: clean, source: c_text_source_ocr},
+		{name: "born digital page", ocr: noise, layer: clean, source: c_text_source_text_layer},
+		{name: "equal quality prefers the text layer", ocr: clean, layer: clean, source: c_text_source_text_layer},
+		{name: "garbled text layer", ocr: clean, layer: noise, source: c_text_source_ocr},
+		{name: "stray characters in the text layer", ocr: noise, layer: "1 .", source: c_text_source_ocr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			pp := PendingPage{
+				Identifier:    "page",
+				OCRTextPath:   filepath.Join(dir, "ocr.000001.txt"),
+				TextLayerPath: filepath.Join(dir, "text.000001.txt"),
+			}
+			if err := os.WriteFile(pp.OCRTextPath, []byte(tt.ocr), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.layer) > 0 {
+				if err := os.WriteFile(pp.TextLayerPath, []byte(tt.layer), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			pp = chooseBestText(pp)
+			if pp.TextSource != tt.source {
+				t.Errorf("chooseBestText() TextSource = %v, want %v", pp.TextSource, tt.source)
+			}
+			if want := map[string]string{c_text_source_ocr: pp.OCRTextPath, c_text_source_text_layer: pp.TextLayerPath}[tt.source]; pp.BestTextPath() != want {
+				t.Errorf("BestTextPath() = %v, want %v", pp.BestTextPath(), want)
+			}
+		})
+	}
+}
+
+// useTestDictionary loads the words into the m_language_dictionary for the test and restores the dictionary after it.
+func useTestDictionary(t *testing.T, words ...string) {
+	dictionary := m_language_dictionary
+	loaded := a_b_dictionary_loaded.Load()
+	t.Cleanup(func() {
+		m_language_dictionary = dictionary
+		a_b_dictionary_loaded.Store(loaded)
+	})
+	english := make(map[string]struct{})
+	for _, word := range words {
+		english[word] = struct{}{}
+	}
+	m_language_dictionary = map[string]map[string]struct{}{"english": english}
+	a_b_dictionary_loaded.Store(true)
+}
+
+func Test_textLayerSkipsOcr(t *testing.T) {
+	useTestDictionary(t, "the", "memorandum", "was", "sent", "to", "director", "of", "agency")
+	forceOcr, chars, ratio := *flag_b_force_ocr, *flag_i_skip_ocr_chars, *flag_f_skip_ocr_dictionary
+	defer func() {
+		*flag_b_force_ocr, *flag_i_skip_ocr_chars, *flag_f_skip_ocr_dictionary = forceOcr, chars, ratio
+	}()
+	*flag_i_skip_ocr_chars = 40
+	*flag_f_skip_ocr_dictionary = 0.7
+
+	const clean = "The memorandum was sent to the director of the agency on 12/03/1963."
+	tests := []struct {
+		name     string
+		layer    string // blank when the PDF of the page does not have a text layer
+		forceOcr bool
+		skip     bool
+		reason   string
+	}{
+		{name: "reliable text layer", layer: clean, skip: true, reason: "of its words are in the dictionary"},
+		{name: "forced", layer: clean, forceOcr: true, reason: "-force-ocr"},
+		{name: "no text layer", reason: "does not have a text layer"},
+		{name: "too short", layer: "The memorandum", reason: "-skip-ocr-chars"},
+		{name: "garbled text layer", layer: "Tbe rnernorandurn wos seut ta tbe dlrector af tbe ageucy", reason: "-skip-ocr-dictionary"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*flag_b_force_ocr = tt.forceOcr
+			pp := PendingPage{Identifier: "page", TextLayerPath: filepath.Join(t.TempDir(), "text.000001.txt")}
+			if len(tt.layer) > 0 {
+				if err := os.WriteFile(pp.TextLayerPath, []byte(tt.layer), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			skip, reason := textLayerSkipsOcr(context.Background(), pp)
+			if skip != tt.skip || !strings.Contains(reason, tt.reason) {
+				t.Errorf("textLayerSkipsOcr() = %v, %q, want %v and a reason with %q", skip, reason, tt.skip, tt.reason)
+			}
+		})
+	}
+}
+
+func Test_performOcrOnPdfSkipsOcr(t *testing.T) {
+	resetJournalState()
+	defer resetJournalState()
+	useTestDictionary(t, "the", "memorandum", "was", "sent", "to", "director", "of", "agency")
+
+	const layer = "The memorandum was sent to the director of the agency. The memorandum was sent to the agency."
+	fake := useFakeToolRunner(t, map[string]func(cmd ToolCommand) (ToolResult, error){
+		"pdftotext": func(cmd ToolCommand) (ToolResult, error) {
+			return ToolResult{}, os.WriteFile(cmd.Args[len(cmd.Args)-1], []byte(strings.Repeat(layer+"\n", 3)), 0644)
+		},
+	})
+	pagesDir := t.TempDir()
+	pp := PendingPage{
+		Identifier:       "page",
+		RecordIdentifier: "record",
+		PageNumber:       1,
+		PDFPath:          filepath.Join(pagesDir, "page.000001.pdf"),
+		OCRTextPath:      filepath.Join(pagesDir, "ocr.000001.txt"),
+		TextLayerPath:    filepath.Join(pagesDir, "text.000001.txt"),
+		ManifestPath:     filepath.Join(pagesDir, "page.000001.json"),
+	}
+
+	wg_active_tasks.Add(1)
+	performOcrOnPdf(context.Background(), pp)
+	got, err := ch_ConvertToJpg.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent, ok := got.(PendingPage)
+	if !ok || !sent.OCRSkipped || sent.TextSource != c_text_source_text_layer || len(sent.OCRReason) == 0 {
+		t.Errorf("Expected the page to skip OCR and use its text layer, but got %+v", got)
+	}
+	if commands := fake.Commands(); len(commands) != 1 || !strings.HasPrefix(commands[0], "pdftotext ") {
+		t.Errorf("Expected only pdftotext to run, but got %v", commands)
+	}
+	var manifest PendingPage
+	contents, err := os.ReadFile(pp.ManifestPath)
+	if err == nil {
+		err = json.Unmarshal(contents, &manifest)
+	}
+	if err != nil || !manifest.OCRSkipped || manifest.OCRReason != sent.OCRReason {
+		t.Errorf("Expected the manifest to record why OCR was skipped, but got %s (error %v)", contents, err)
+	}
+}
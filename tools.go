@@ -0,0 +1,152 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`bytes`
+	`context`
+	`errors`
+	`fmt`
+	`log`
+	`os/exec`
+	`strings`
+	`time`
+
+	`go-vue-sql-apario/sema`
+)
+
+// c_tool_wait_delay is how long a binary that was killed may keep its output open before Wait gives up on it.
+const c_tool_wait_delay = 5 * time.Second
+
+var errToolTimeout = errors.New("tool timed out")
+
+// ToolCommand is a single run of one of the sl_required_binaries. The Semaphore is held while the binary runs and a
+// Timeout of zero uses the -timeout-<binary> flag of the binary.
+type ToolCommand struct {
+	Name      string
+	Args      []string
+	Semaphore sema.Semaphore
+	Timeout   time.Duration
+}
+
+// ToolResult is the captured output of a ToolCommand.
+type ToolResult struct {
+	Stdout string
+	Stderr string
+}
+
+// ToolRunner runs the external binaries of the pipeline. The ExecToolRunner is used by the engine and the tests swap
+// tool_runner for a fake so the pipeline can run without gs, pdftotext or tesseract installed.
+type ToolRunner interface {
+	Run(ctx context.Context, cmd ToolCommand) (ToolResult, error)
+}
+
+// ExecToolRunner runs the binaries that verifyBinaries found in m_required_binaries. Each binary runs in its own
+// process group, which is killed when the Timeout expires or the ctx is canceled.
+type ExecToolRunner struct{}
+
+func (r ExecToolRunner) Run(ctx context.Context, cmd ToolCommand) (ToolResult, error) {
+	binary, found := m_required_binaries[cmd.Name]
+	if !found || len(binary) == 0 {
+		return ToolResult{}, fmt.Errorf("the binary %v was not found by verifyBinaries", cmd.Name)
+	}
+	if cmd.Semaphore != nil {
+		cmd.Semaphore.Acquire()
+		defer cmd.Semaphore.Release()
+	}
+	// the timeout starts once the semaphore is acquired so that waiting for a slot does not count against it
+	runCtx := ctx
+	if cmd.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, cmd.Timeout)
+		defer cancel()
+	}
+
+	command := exec.CommandContext(runCtx, binary, cmd.Args...)
+	configureProcessGroup(command)
+	command.WaitDelay = c_tool_wait_delay
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	err := command.Start()
+	if err != nil {
+		return ToolResult{}, err
+	}
+	pid := command.Process.Pid
+	sm_running_tools.Store(pid, command)
+	err = command.Wait()
+	sm_running_tools.Delete(pid)
+
+	result := ToolResult{Stdout: stdout.String(), Stderr: stderr.String()}
+	if err != nil && runCtx.Err() != nil {
+		if ctx.Err() != nil {
+			return result, fmt.Errorf("`%v` was stopped: %w", cmd, ctx.Err())
+		}
+		return result, fmt.Errorf("%w: `%v` ran longer than %v", errToolTimeout, cmd, cmd.Timeout)
+	}
+	return result, err
+}
+
+// killRunningTools kills the process group of every binary that is still running, which the watchdog does before the
+// engine exits so that no tesseract or gs outlives it.
+func killRunningTools() {
+	sm_running_tools.Range(func(key, value any) bool {
+		command, ok := value.(*exec.Cmd)
+		if !ok {
+			return true
+		}
+		err := killProcessGroup(command)
+		if err != nil {
+			log.Printf("failed to kill the process group of pid %v due to error %v", key, err)
+		}
+		return true
+	})
+}
+
+// toolTimeout returns the -timeout-<binary> flag of the binary.
+func toolTimeout(name string) time.Duration {
+	switch name {
+	case "gs":
+		return *flag_d_timeout_gs
+	case "pdftotext":
+		return *flag_d_timeout_pdftotext
+	case "convert":
+		return *flag_d_timeout_convert
+	case "pdftoppm":
+		return *flag_d_timeout_pdftoppm
+	case "tesseract":
+		return *flag_d_timeout_tesseract
+	case "qpdf":
+		return *flag_d_timeout_qpdf
+	}
+	return 0
+}
+
+// String returns the command line of the cmd for log messages.
+func (cmd ToolCommand) String() string {
+	return strings.TrimSpace(cmd.Name + " " + strings.Join(cmd.Args, " "))
+}
+
+// runTool runs the cmd with the tool_runner, using the -timeout-<binary> flag when the cmd does not have a Timeout.
+func runTool(ctx context.Context, cmd ToolCommand) (ToolResult, error) {
+	if cmd.Timeout == 0 {
+		cmd.Timeout = toolTimeout(cmd.Name)
+	}
+	return tool_runner.Run(ctx, cmd)
+}
@@ -0,0 +1,144 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`context`
+	`errors`
+	`os/exec`
+	`strings`
+	`sync`
+	`testing`
+	`time`
+)
+
+// FakeToolRunner records every ToolCommand and answers it with the function registered for the binary, so the pipeline
+// can be tested without the binaries installed. Binaries without a function succeed without any output.
+type FakeToolRunner struct {
+	mu    sync.Mutex
+	Calls []ToolCommand
+	Tools map[string]func(cmd ToolCommand) (ToolResult, error)
+}
+
+func (f *FakeToolRunner) Run(ctx context.Context, cmd ToolCommand) (ToolResult, error) {
+	f.mu.Lock()
+	f.Calls = append(f.Calls, cmd)
+	tool := f.Tools[cmd.Name]
+	f.mu.Unlock()
+	if tool == nil {
+		return ToolResult{}, nil
+	}
+	return tool(cmd)
+}
+
+// Commands returns the command lines that were run, in order.
+func (f *FakeToolRunner) Commands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var commands []string
+	for _, cmd := range f.Calls {
+		commands = append(commands, cmd.String())
+	}
+	return commands
+}
+
+// useFakeToolRunner replaces the tool_runner for the duration of the test.
+func useFakeToolRunner(t *testing.T, tools map[string]func(cmd ToolCommand) (ToolResult, error)) *FakeToolRunner {
+	runner := tool_runner
+	t.Cleanup(func() {
+		tool_runner = runner
+	})
+	fake := &FakeToolRunner{Tools: tools}
+	tool_runner = fake
+	return fake
+}
+
+func Test_ExecToolRunner(t *testing.T) {
+	echo, err := exec.LookPath("echo")
+	if err != nil {
+		t.Skip("echo is not installed")
+	}
+	m_required_binaries["echo"] = echo
+	defer delete(m_required_binaries, "echo")
+	if sh, shErr := exec.LookPath("sh"); shErr == nil {
+		m_required_binaries["sh"] = sh
+		defer delete(m_required_binaries, "sh")
+	}
+
+	tests := []struct {
+		name    string
+		cmd     ToolCommand
+		stdout  string
+		wantErr bool
+		timeout bool
+	}{
+		{name: "captures stdout", cmd: ToolCommand{Name: "echo", Args: []string{"validation", "ok"}, Semaphore: sem_pdfcpu}, stdout: "validation ok"},
+		{name: "unknown binary", cmd: ToolCommand{Name: "missing"}, wantErr: true},
+		{name: "timeout kills the process group", cmd: ToolCommand{Name: "sh", Args: []string{"-c", "sleep 30; echo late"}, Timeout: 100 * time.Millisecond}, wantErr: true, timeout: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cmd.Name == "sh" && len(m_required_binaries["sh"]) == 0 {
+				t.Skip("sh is not installed")
+			}
+			started := time.Now()
+			result, err := ExecToolRunner{}.Run(context.Background(), tt.cmd)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, errToolTimeout) != tt.timeout {
+				t.Errorf("Run() error = %v, want a timeout %v", err, tt.timeout)
+			}
+			if elapsed := time.Since(started); elapsed > c_tool_wait_delay {
+				t.Errorf("Expected Run() to return once the process group was killed, but it took %v", elapsed)
+			}
+			if got := strings.TrimSpace(result.Stdout); got != tt.stdout {
+				t.Errorf("Run() stdout = %q, want %q", got, tt.stdout)
+			}
+		})
+	}
+}
+
+func Test_runToolTimeout(t *testing.T) {
+	timeout := *flag_d_timeout_tesseract
+	defer func() {
+		*flag_d_timeout_tesseract = timeout
+	}()
+	*flag_d_timeout_tesseract = time.Minute
+
+	fake := useFakeToolRunner(t, nil)
+	tests := []struct {
+		name string
+		cmd  ToolCommand
+		want time.Duration
+	}{
+		{name: "flag of the binary", cmd: ToolCommand{Name: "tesseract"}, want: time.Minute},
+		{name: "own timeout", cmd: ToolCommand{Name: "tesseract", Timeout: time.Second}, want: time.Second},
+		{name: "unknown binary", cmd: ToolCommand{Name: "echo"}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := runTool(context.Background(), tt.cmd); err != nil {
+				t.Fatal(err)
+			}
+			if got := fake.Calls[i].Timeout; got != tt.want {
+				t.Errorf("runTool() timeout = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
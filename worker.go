@@ -22,13 +22,16 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"image"
 	"image/color"
 	"image/draw"
@@ -37,7 +40,6 @@ import (
 	"io/fs"
 	"log"
 	"math/big"
-	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -45,6 +47,7 @@ import (
 	"regexp"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -85,17 +88,18 @@ func fileHasData(filename string) (bool, error) {
 
 }
 
-func parseDateString(in string) (out time.Time, err error) {
+// parseDateString tries the formats (usually from the Profile) before the built-in formats.
+func parseDateString(in string, formats ...string) (out time.Time, err error) {
 	wg_active_tasks.Add(1)
 	defer wg_active_tasks.Done()
 
-	possibleFormats := []string{
+	possibleFormats := append(append([]string{}, formats...),
 		"01-02-06",
 		"01/02/2006",
 		"01-02-2006",
 		"01/02/2006",
 		"2006-01-02T15:04:05-07:00",
-	}
+	)
 
 	for _, format := range possibleFormats {
 		out, err = time.Parse(format, in)
@@ -223,35 +227,59 @@ func cryptoRandInt(min, max int) (int, error) {
 	return int(n.Int64()) + min, nil
 }
 
-func downloadFile(ctx context.Context, url string, output string) error {
+// downloadFile downloads the url with the Fetcher from fetcherFor into output through an output.part file that is
+// resumed with a Range request when a previous attempt was interrupted. The output only appears once the download is
+// complete and, when an expected checksum is given, once it matches. The validators of the cache are sent with the
+// request so that errNotModified is returned when the server still has the same file, and the validators of the new
+// download are returned.
+func downloadFile(ctx context.Context, url string, output string, checksum string, cache DownloadCache) (DownloadCache, error) {
 	wg_active_tasks.Add(1)
 	defer wg_active_tasks.Done()
 
+	fetcher := fetcherFor(url)
 	var err error
 	for i := 0; i < c_retry_attempts; i++ {
-		err = tryDownloadFile(ctx, url, output)
-		if err == nil {
-			return nil
+		cache, err = fetcher.Fetch(ctx, url, output+c_partial_download_ext, cache)
+		if err == nil || errors.Is(err, errNotModified) {
+			break
 		}
 
-		var netErr net.Error
-		if errors.As(err, &netErr) && netErr.Timeout() {
-			wait, _ := cryptoRandInt(0, 1<<i)
-			select {
-			case <-time.After(time.Duration(wait) * time.Second):
-				continue
-			case <-ctx.Done():
-				return ctx.Err()
-			}
-		} else {
-			log.Printf("downloadFile returned an err: %v", err)
+		class, retryable, wait := classifyDownloadError(err)
+		if !retryable || i == c_retry_attempts-1 {
+			log.Printf("downloadFile gave up on %v after %d attempts (%v) due to error %v", url, i+1, class, err)
 			break
 		}
+		backoff := retryBackoff(i, wait)
+		log.Printf("retrying the download of %v in %v (%v) due to error %v", url, backoff, class, err)
+		select {
+		case <-time.After(backoff):
+			continue
+		case <-ctx.Done():
+			return cache, ctx.Err()
+		}
+	}
+	if err != nil {
+		return cache, err
+	}
+
+	if len(checksum) > 0 {
+		verifyErr := verifyChecksum(output+c_partial_download_ext, checksum)
+		if verifyErr != nil {
+			// the bytes are wrong rather than missing so resuming would not help
+			removeErr := os.Remove(output + c_partial_download_ext)
+			if removeErr != nil {
+				log.Printf("failed to remove %v due to error %v", output+c_partial_download_ext, removeErr)
+			}
+			return cache, fmt.Errorf("%w: download of %v failed verification: %v", errChecksumMismatch, url, verifyErr)
+		}
 	}
-	return err
+	return cache, os.Rename(output+c_partial_download_ext, output)
 }
 
-func tryDownloadFile(ctx context.Context, url string, output string) error {
+// tryDownloadFile downloads the url into the partial file, continuing from the end of the partial file when the server
+// supports Range requests and starting over when it does not. A conditional request is made with the validators of the
+// cache when there is no partial file to continue.
+func tryDownloadFile(ctx context.Context, url string, partial string, cache DownloadCache) (DownloadCache, error) {
 	wg_active_tasks.Add(1)
 	defer wg_active_tasks.Done()
 
@@ -259,24 +287,174 @@ func tryDownloadFile(ctx context.Context, url string, output string) error {
 	defer sem_download.Release()
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return cache, err
+	}
+	req.Header.Set("User-Agent", *flag_s_user_agent)
+	err = rateLimitHost(ctx, req.URL.Host)
+	if err != nil {
+		return cache, err
+	}
+
+	var offset int64
+	if info, statErr := os.Stat(partial); statErr == nil {
+		offset = info.Size()
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	} else {
+		if len(cache.ETag) > 0 {
+			req.Header.Set("If-None-Match", cache.ETag)
+		}
+		if len(cache.LastModified) > 0 {
+			req.Header.Set("If-Modified-Since", cache.LastModified)
+		}
+	}
+
+	resp, err := downloadClient().Do(req)
+	if err != nil {
+		return cache, err
+	}
+	defer resp.Body.Close()
+
+	var expectedSize int64 = -1
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return cache, errNotModified
+	case http.StatusOK:
+		flags |= os.O_TRUNC
+		offset = 0
+		expectedSize = resp.ContentLength
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+		start, total, rangeErr := parseContentRange(resp.Header.Get("Content-Range"))
+		if rangeErr != nil {
+			return cache, rangeErr
+		}
+		if start != offset {
+			return cache, fmt.Errorf("requested bytes from %d of %v but the server sent bytes from %d", offset, url, start)
+		}
+		expectedSize = total
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the partial file is not a prefix of what the server has now, start over on the next attempt
+		removeErr := os.Remove(partial)
+		if removeErr != nil {
+			return cache, removeErr
+		}
+		return cache, fmt.Errorf("%w: the server rejected the range of %v starting at %d", errIncompleteDownload, url, offset)
+	default:
+		wait := retryAfter(resp.Header.Get("Retry-After"), time.Now())
+		delayHost(req.URL.Host, wait)
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+		return cache, &DownloadError{URL: url, StatusCode: resp.StatusCode, RetryAfter: wait}
+	}
+	if offset > 0 {
+		log.Printf("resuming the download of %v at %d bytes", url, offset)
+	}
+
+	out, err := os.OpenFile(partial, flags, 0644)
+	if err != nil {
+		return cache, err
+	}
+	defer out.Close()
+
+	written, err := io.Copy(out, resp.Body)
+	if err != nil {
+		return cache, err
+	}
+	if expectedSize >= 0 && offset+written != expectedSize {
+		return cache, fmt.Errorf("%w: received %d of %d bytes of %v", errIncompleteDownload, offset+written, expectedSize, url)
+	}
+	return DownloadCache{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		CheckedAt:    time.Now().UTC(),
+	}, nil
+}
+
+// parseContentRange returns the first byte and the total size from a Content-Range header such as bytes 100-199/200,
+// where the total size is -1 when the server sent * instead.
+func parseContentRange(contentRange string) (start int64, total int64, err error) {
+	var end int64
+	var size string
+	_, err = fmt.Sscanf(contentRange, "bytes %d-%d/%s", &start, &end, &size)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot parse the Content-Range %q due to error %v", contentRange, err)
+	}
+	if size == "*" {
+		return start, -1, nil
+	}
+	total, err = strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot parse the Content-Range %q due to error %v", contentRange, err)
+	}
+	return start, total, nil
+}
+
+// verifyChecksum compares the file with a hex encoded MD5, SHA1, SHA256 or SHA512 checksum, picked by its length.
+func verifyChecksum(filename string, checksum string) error {
+	wg_active_tasks.Add(1)
+	defer wg_active_tasks.Done()
+
+	checksum = strings.ToLower(strings.TrimSpace(checksum))
+	var hasher hash.Hash
+	switch len(checksum) {
+	case 32:
+		hasher = md5.New()
+	case 40:
+		hasher = sha1.New()
+	case 64:
+		hasher = sha256.New()
+	case 128:
+		hasher = sha512.New()
+	default:
+		return fmt.Errorf("unsupported checksum %q", checksum)
+	}
+
+	sem_shafile.Acquire()
+	defer sem_shafile.Release()
+
+	file, err := os.Open(filename)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
+	if _, err = io.Copy(hasher, file); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != checksum {
+		return fmt.Errorf("expected the checksum %v but got %v", checksum, actual)
+	}
+	return nil
+}
 
-	resp, err := http.DefaultClient.Do(req)
+// copyFile copies a PDF that is already on disk into the record directory in place of downloadFile.
+func copyFile(input string, output string) error {
+	wg_active_tasks.Add(1)
+	defer wg_active_tasks.Done()
+
+	in, err := os.Open(input)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	defer in.Close()
 
-	out, err := os.Create(output)
+	out, err := os.Create(output + c_partial_download_ext)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
 
-	_, err = io.Copy(out, resp.Body)
-	return err
+	_, err = io.Copy(out, in)
+	closeErr := out.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return os.Rename(output+c_partial_download_ext, output)
 }
 
 func Sha256(in string) (checksum string) {
@@ -684,13 +862,70 @@ func NewIdentifier(length int) string {
 
 		if !exists {
 			mu_identifier.Lock()
-			m_used_identifiers[id] = true
+			m_used_identifiers[id] = ""
 			mu_identifier.Unlock()
 			return id
 		}
 	}
 }
 
+// NewStableIdentifier returns an identifier of length characters from c_identifier_charset that is derived from the
+// SHA256 checksum of the seed, so the same seed produces the same identifier on every run and on every machine. When a
+// different seed already claimed the identifier, the seed is re-hashed with a counter until a free identifier is found.
+func NewStableIdentifier(length int, seed string) string {
+	wg_active_tasks.Add(1)
+	defer wg_active_tasks.Done()
+
+	mu_identifier.Lock()
+	defer mu_identifier.Unlock()
+
+	base := big.NewInt(int64(len(c_identifier_charset)))
+	for attempt := 0; ; attempt++ {
+		salted := seed
+		if attempt > 0 {
+			salted = fmt.Sprintf("%v#%d", seed, attempt)
+		}
+		sum := sha256.Sum256([]byte(salted))
+		n, remainder := new(big.Int).SetBytes(sum[:]), new(big.Int)
+		identifier := make([]byte, length)
+		for i := range identifier {
+			n.DivMod(n, base, remainder)
+			identifier[i] = c_identifier_charset[remainder.Int64()]
+		}
+
+		id := string(identifier)
+		owner, exists := m_used_identifiers[id]
+		if !exists || owner == seed {
+			m_used_identifiers[id] = seed
+			return id
+		}
+		log.Printf("identifier %v for seed %v is already used by seed %v, trying again", id, seed, owner)
+	}
+}
+
+func recordIdentifierSeed(urlChecksum string) string {
+	return "record:" + urlChecksum
+}
+
+func pageIdentifierSeed(recordIdentifier string, pageNumber int) string {
+	return fmt.Sprintf("page:%v:%d", recordIdentifier, pageNumber)
+}
+
+// NewRecordIdentifier returns the stable identifier of the record whose PDF URL has the urlChecksum.
+func NewRecordIdentifier(urlChecksum string) string {
+	return NewStableIdentifier(c_record_id_length, recordIdentifierSeed(urlChecksum))
+}
+
+// NewPageIdentifier returns the stable identifier of the pageNumber of the record.
+func NewPageIdentifier(recordIdentifier string, pageNumber int) string {
+	return NewStableIdentifier(c_page_id_length, pageIdentifierSeed(recordIdentifier, pageNumber))
+}
+
+// NewCollectionIdentifier returns the stable identifier of the collection with the name, ignoring its case.
+func NewCollectionIdentifier(name string) string {
+	return NewStableIdentifier(c_collection_id_length, "collection:"+strings.ToLower(strings.TrimSpace(name)))
+}
+
 func WritePendingPageToJson(pp PendingPage) error {
 	wg_active_tasks.Add(1)
 	defer wg_active_tasks.Done()
@@ -713,6 +948,16 @@ func WritePendingPageToJson(pp PendingPage) error {
 	return nil
 }
 
+func ReadResultDataFromJson(path string) (ResultData, error) {
+	var rd ResultData
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return rd, err
+	}
+	err = json.Unmarshal(contents, &rd)
+	return rd, err
+}
+
 func WriteResultDataToJson(rd ResultData) error {
 	wg_active_tasks.Add(1)
 	defer wg_active_tasks.Done()
@@ -0,0 +1,148 @@
+/*
+Project Apario is the World's Truth Repository that was invented and started by Andrei Merlescu in 2020.
+Copyright (C) 2023  Andrei Merlescu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	`bytes`
+	`context`
+	`crypto/md5`
+	`crypto/sha256`
+	`encoding/hex`
+	`net/http`
+	`net/http/httptest`
+	`os`
+	`path/filepath`
+	`strconv`
+	`strings`
+	`sync/atomic`
+	`testing`
+	`time`
+)
+
+func Test_NewStableIdentifier(t *testing.T) {
+	urlChecksum := Sha256("https://www.archives.gov/files/research/jfk/releases/2023/104-10061-10328.pdf")
+
+	record := NewRecordIdentifier(urlChecksum)
+	if len(record) != c_record_id_length {
+		t.Errorf("Expected a record identifier of %d characters, but got %v", c_record_id_length, record)
+	}
+	for _, r := range record {
+		if !strings.ContainsRune(c_identifier_charset, r) {
+			t.Errorf("Expected %v to only use characters from %v", record, c_identifier_charset)
+		}
+	}
+	if again := NewRecordIdentifier(urlChecksum); again != record {
+		t.Errorf("Expected the same URL checksum to produce %v, but got %v", record, again)
+	}
+
+	page1, page2 := NewPageIdentifier(record, 1), NewPageIdentifier(record, 2)
+	if len(page1) != c_page_id_length || page1 == page2 {
+		t.Errorf("Expected distinct page identifiers of %d characters, but got %v and %v", c_page_id_length, page1, page2)
+	}
+	if again := NewPageIdentifier(record, 1); again != page1 {
+		t.Errorf("Expected page 1 to produce %v, but got %v", page1, again)
+	}
+
+	if NewCollectionIdentifier("JFK") != NewCollectionIdentifier(" jfk ") {
+		t.Errorf("Expected collection identifiers to ignore case and whitespace")
+	}
+
+	seed := "record:collision"
+	claimed := NewStableIdentifier(c_record_id_length, seed)
+	mu_identifier.Lock()
+	m_used_identifiers[claimed] = "record:someone-else"
+	mu_identifier.Unlock()
+	if got := NewStableIdentifier(c_record_id_length, seed); got == claimed || len(got) != c_record_id_length {
+		t.Errorf("Expected a colliding identifier to be re-hashed, but got %v", got)
+	}
+}
+
+func Test_downloadFile(t *testing.T) {
+	rateLimit := *flag_f_rate_limit
+	defer func() {
+		*flag_f_rate_limit = rateLimit
+	}()
+	*flag_f_rate_limit = 0
+
+	content := bytes.Repeat([]byte("%PDF-1.4 apario "), 4096)
+	md5Sum := md5.Sum(content)
+	sha256Sum := sha256.Sum256(content)
+
+	var requests, truncated atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path == "/truncated.pdf" && truncated.Add(1) == 1 {
+			// promise the whole file but hang up halfway through it
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			_, _ = w.Write(content[:len(content)/2])
+			return
+		}
+		if r.URL.Path == "/missing.pdf" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "file.pdf", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		partial  []byte
+		checksum string
+		requests int32
+		wantErr  bool
+	}{
+		{name: "md5 checksum", path: "/file.pdf", checksum: hex.EncodeToString(md5Sum[:]), requests: 1},
+		{name: "resume partial file", path: "/file.pdf", partial: content[:1000], checksum: hex.EncodeToString(sha256Sum[:]), requests: 1},
+		{name: "resume after hang up", path: "/truncated.pdf", requests: 2},
+		{name: "checksum mismatch", path: "/file.pdf", checksum: strings.Repeat("0", 64), requests: 1, wantErr: true},
+		{name: "not found", path: "/missing.pdf", requests: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests.Store(0)
+			output := filepath.Join(t.TempDir(), "file.pdf")
+			if tt.partial != nil {
+				if err := os.WriteFile(output+c_partial_download_ext, tt.partial, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_, err := downloadFile(context.Background(), server.URL+tt.path, output, tt.checksum, DownloadCache{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("downloadFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := requests.Load(); got != tt.requests {
+				t.Errorf("downloadFile() made %d requests, want %d", got, tt.requests)
+			}
+			got, readErr := os.ReadFile(output)
+			if tt.wantErr {
+				if !os.IsNotExist(readErr) {
+					t.Errorf("Expected a failed download to not create %v", output)
+				}
+				return
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("downloadFile() wrote %d bytes, want %d", len(got), len(content))
+			}
+			if _, statErr := os.Stat(output + c_partial_download_ext); !os.IsNotExist(statErr) {
+				t.Errorf("Expected the partial file to be renamed to %v", output)
+			}
+		})
+	}
+}